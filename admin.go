@@ -0,0 +1,297 @@
+// See LICENSE file for copyright and license details
+
+package shrt
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// maxAdminBody limits the size of request bodies accepted by
+// AdminHandler.
+const maxAdminBody = 64 << 10
+
+// AdminHandler is an [http.Handler] implementing a REST API for
+// managing the entries of a database file. Every request must be
+// authenticated by Credentials.
+//
+// The API consists of the following endpoints, relative to Prefix:
+//
+//	GET    /links      list all entries
+//	POST   /links      create an entry
+//	GET    /links/KEY  get the entry for KEY
+//	PUT    /links/KEY  create or replace the entry for KEY
+//	DELETE /links/KEY  delete the entry for KEY
+//	POST   /reload     reload the database
+//
+// Entries are represented as JSON objects with "key", "type" and
+// "url" members. An entry posted to /links without a type is a
+// shortlink, and one without a key is given a key generated by Keys,
+// which is included in the response. Errors are represented as JSON
+// objects with an "error" member, and an "errors" member listing every
+// problem when the database file is invalid.
+//
+// Entries are read from the database being served by Handler.
+// Changes are made to the file at Path with [EditShrtFile], after
+// which the database is reloaded, so the API can be used alongside
+// hand edits of the file.
+type AdminHandler struct {
+	// Handler is the ShrtHandler serving the database.
+	Handler *ShrtHandler
+	// Path is the operating system path of the database file.
+	Path string
+	// Prefix is removed from request paths before routing.
+	Prefix string
+	// Credentials authenticate requests. If nil, every request is
+	// refused.
+	Credentials *Credentials
+	// Reload, if not nil, is called to reload the database after a
+	// change and for the reload endpoint. Otherwise,
+	// Handler.Reload is used.
+	Reload func() error
+	// Keys generates the keys of entries created without one. If
+	// nil, the zero KeyGenerator is used.
+	Keys *KeyGenerator
+}
+
+type adminEntry struct {
+	Key  string   `json:"key"`
+	Type ShrtType `json:"type"`
+	URL  string   `json:"url"`
+}
+
+// ServeHTTP implements the http.Handler interface.
+func (a *AdminHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	user, ok := a.Credentials.Authenticate(req)
+	if !ok {
+		w.Header().Set("WWW-Authenticate", `Basic realm="shrt", charset="UTF-8"`)
+		w.Header().Add("WWW-Authenticate", `Bearer realm="shrt"`)
+		adminError(w, http.StatusUnauthorized, errors.New("unauthorized"))
+		return
+	}
+
+	p := strings.TrimPrefix(req.URL.Path, a.Prefix)
+	p = strings.Trim(p, "/")
+	switch {
+	case p == "links":
+		switch req.Method {
+		case http.MethodGet, http.MethodHead:
+			a.list(w)
+		case http.MethodPost:
+			a.create(w, req, user)
+		default:
+			methodNotAllowed(w, "GET, HEAD, POST")
+		}
+	case strings.HasPrefix(p, "links/"):
+		key := strings.TrimPrefix(p, "links/")
+		switch req.Method {
+		case http.MethodGet, http.MethodHead:
+			a.get(w, key)
+		case http.MethodPut:
+			a.put(w, req, user, key)
+		case http.MethodDelete:
+			a.delete(w, user, key)
+		default:
+			methodNotAllowed(w, "GET, HEAD, PUT, DELETE")
+		}
+	case p == "reload":
+		if req.Method != http.MethodPost {
+			methodNotAllowed(w, "POST")
+			return
+		}
+		log.Printf("admin: %s requested reload", user)
+		a.reload(w, http.StatusOK, nil)
+	default:
+		adminError(w, http.StatusNotFound, errors.New("not found"))
+	}
+}
+
+func (a *AdminHandler) list(w http.ResponseWriter) {
+	snap := a.Handler.ShrtFile.Snapshot()
+	entries := make([]adminEntry, 0, snap.Len())
+	for _, key := range snap.Keys() {
+		val, _ := snap.Get(key)
+		entries = append(entries, adminEntry{Key: key, Type: val.Type, URL: val.URL})
+	}
+	writeJSON(w, http.StatusOK, entries)
+}
+
+func (a *AdminHandler) get(w http.ResponseWriter, key string) {
+	val, err := a.Handler.ShrtFile.Get(key)
+	if err != nil {
+		adminError(w, http.StatusNotFound, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, adminEntry{Key: key, Type: val.Type, URL: val.URL})
+}
+
+func (a *AdminHandler) create(w http.ResponseWriter, req *http.Request, user string) {
+	var e adminEntry
+	if !readJSON(w, req, &e) {
+		return
+	}
+	err := EditShrtFile(a.Path, func(ed *Editor) error {
+		return createEntry(ed, a.Keys, &e)
+	})
+	if a.editError(w, err) {
+		return
+	}
+	log.Printf("admin: %s created %s", user, FormatEntry(e.Key, ShrtEntry{Type: e.Type, URL: e.URL}))
+	a.reload(w, http.StatusCreated, e)
+}
+
+func (a *AdminHandler) put(w http.ResponseWriter, req *http.Request, user, key string) {
+	var e adminEntry
+	if !readJSON(w, req, &e) {
+		return
+	}
+	if e.Key != "" && e.Key != key {
+		adminError(w, http.StatusBadRequest, fmt.Errorf("key in body does not match path: %s", e.Key))
+		return
+	}
+	e.Key = key
+	status := http.StatusOK
+	err := EditShrtFile(a.Path, func(ed *Editor) error {
+		if _, ok := ed.Get(key); !ok {
+			status = http.StatusCreated
+		}
+		return ed.Set(key, ShrtEntry{Type: e.Type, URL: e.URL})
+	})
+	if a.editError(w, err) {
+		return
+	}
+	log.Printf("admin: %s set %s", user, FormatEntry(key, ShrtEntry{Type: e.Type, URL: e.URL}))
+	a.reload(w, status, e)
+}
+
+func (a *AdminHandler) delete(w http.ResponseWriter, user, key string) {
+	err := EditShrtFile(a.Path, func(ed *Editor) error {
+		if !ed.Delete(key) {
+			return errNotExist
+		}
+		return nil
+	})
+	if a.editError(w, err) {
+		return
+	}
+	log.Printf("admin: %s deleted %s", user, key)
+	a.reload(w, http.StatusNoContent, nil)
+}
+
+// createEntry adds e to the file being edited by ed. If e has no key,
+// one is generated by keys and stored in e, and if it has no type, it
+// is made a shortlink, as by 'shrt add URL'.
+func createEntry(ed *Editor, keys *KeyGenerator, e *adminEntry) error {
+	if e.Type == NoneType {
+		e.Type = ShortLink
+	}
+	if e.Key == "" {
+		if keys == nil {
+			keys = new(KeyGenerator)
+		}
+		key, err := keys.Generate(e.URL, func(key string) bool {
+			_, ok := ed.Get(key)
+			return ok
+		})
+		if err != nil {
+			return err
+		}
+		e.Key = key
+	} else if _, ok := ed.Get(e.Key); ok {
+		return errExists
+	}
+	return ed.Set(e.Key, ShrtEntry{Type: e.Type, URL: e.URL})
+}
+
+var (
+	errExists   = errors.New("key already exists")
+	errNotExist = errors.New("key does not exist")
+)
+
+// editError writes the response for an error returned by
+// EditShrtFile, and reports whether there was one.
+func (a *AdminHandler) editError(w http.ResponseWriter, err error) bool {
+	var list ErrorList
+	switch {
+	case err == nil:
+		return false
+	case errors.Is(err, errExists):
+		adminError(w, http.StatusConflict, err)
+	case errors.Is(err, errNotExist):
+		adminError(w, http.StatusNotFound, err)
+	case errors.As(err, &list):
+		writeJSON(w, http.StatusConflict, map[string]interface{}{
+			"error":  "database file contains errors",
+			"errors": errorStrings(list),
+		})
+	default:
+		log.Println("admin:", err)
+		adminError(w, http.StatusUnprocessableEntity, err)
+	}
+	return true
+}
+
+// reload reloads the database and, if successful, responds with
+// status and body. A nil body sends the reload status instead.
+func (a *AdminHandler) reload(w http.ResponseWriter, status int, body interface{}) {
+	if err := reloadWith(a.Handler, a.Reload); err != nil {
+		adminError(w, http.StatusInternalServerError, fmt.Errorf("reload failed: %s", err))
+		return
+	}
+	if status == http.StatusNoContent {
+		w.WriteHeader(status)
+		return
+	}
+	if body == nil {
+		st := a.Handler.Status()
+		body = map[string]interface{}{
+			"generation": st.Generation,
+			"loaded":     st.Loaded,
+			"entries":    st.Entries,
+		}
+	}
+	writeJSON(w, status, body)
+}
+
+// reloadWith reloads the database served by h by calling fn, or
+// h.Reload if fn is nil.
+func reloadWith(h *ShrtHandler, fn func() error) error {
+	if fn == nil {
+		fn = h.Reload
+	}
+	return fn()
+}
+
+func readJSON(w http.ResponseWriter, req *http.Request, v interface{}) bool {
+	dec := json.NewDecoder(http.MaxBytesReader(w, req.Body, maxAdminBody))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		adminError(w, http.StatusBadRequest, err)
+		return false
+	}
+	return true
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "\t")
+	if err := enc.Encode(v); err != nil {
+		log.Println("admin: error encoding response:", err)
+	}
+}
+
+func adminError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
+
+func methodNotAllowed(w http.ResponseWriter, allow string) {
+	w.Header().Set("Allow", allow)
+	adminError(w, http.StatusMethodNotAllowed, errors.New("method not allowed"))
+}
@@ -0,0 +1,172 @@
+// See LICENSE file for copyright and license details
+
+package shrt
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func newTestAdmin(t *testing.T, db string) (*AdminHandler, string) {
+	t.Helper()
+	dir := t.TempDir()
+	path := filepath.Join(dir, "shrt.db")
+	if err := os.WriteFile(path, []byte(db), 0666); err != nil {
+		t.Fatal(err)
+	}
+	h := &ShrtHandler{
+		ShrtFile: NewShrtFile(),
+		FS:       os.DirFS(dir),
+		Config:   Config{SrvName: "example.org", ScmType: "git", DbPath: "shrt.db"},
+	}
+	if err := h.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	return &AdminHandler{
+		Handler:     h,
+		Path:        path,
+		Prefix:      "/admin",
+		Credentials: &Credentials{Tokens: []string{"secret"}},
+	}, path
+}
+
+func adminRequest(a *AdminHandler, method, path, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer secret")
+	w := httptest.NewRecorder()
+	a.ServeHTTP(w, req)
+	return w
+}
+
+func TestAdminHandler(t *testing.T) {
+	db := "# Home page\nfoo=shrtlnk:https://example.com/foo\n\n" +
+		"# The bar module\nbar = goget : https://example.com/bar\n"
+	a, path := newTestAdmin(t, db)
+
+	req := httptest.NewRequest(http.MethodGet, "/admin/links", nil)
+	req.Header.Set("Authorization", "Bearer wrong")
+	w := httptest.NewRecorder()
+	a.ServeHTTP(w, req)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("bad token: got status %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+
+	tests := []struct {
+		method, path, body string
+		code               int
+	}{
+		{http.MethodGet, "/admin/links", "", http.StatusOK},
+		{http.MethodGet, "/admin/links/foo", "", http.StatusOK},
+		{http.MethodGet, "/admin/links/baz", "", http.StatusNotFound},
+		{http.MethodPost, "/admin/links", `{"key":"baz","type":"shrtlnk","url":"https://example.com/baz"}`, http.StatusCreated},
+		{http.MethodPost, "/admin/links", `{"key":"baz","type":"shrtlnk","url":"https://example.com/baz"}`, http.StatusConflict},
+		{http.MethodPost, "/admin/links", `{"key":"a/b","type":"shrtlnk","url":"https://example.com/"}`, http.StatusUnprocessableEntity},
+		{http.MethodPost, "/admin/links", `{"key":"qux","type":"nope","url":"https://example.com/"}`, http.StatusBadRequest},
+		{http.MethodPut, "/admin/links/foo", `{"type":"shrtlnk","url":"https://example.com/new"}`, http.StatusOK},
+		{http.MethodDelete, "/admin/links/bar", "", http.StatusNoContent},
+		{http.MethodDelete, "/admin/links/bar", "", http.StatusNotFound},
+		{http.MethodPost, "/admin/reload", "", http.StatusOK},
+		{http.MethodPatch, "/admin/links/foo", "", http.StatusMethodNotAllowed},
+	}
+	for _, tt := range tests {
+		w := adminRequest(a, tt.method, tt.path, tt.body)
+		if w.Code != tt.code {
+			t.Errorf("%s %s: got status %d, want %d: %s", tt.method, tt.path, w.Code, tt.code, w.Body)
+		}
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "# Home page\nfoo=shrtlnk:https://example.com/new\n\n" +
+		"baz=shrtlnk:https://example.com/baz\n"
+	if string(data) != want {
+		t.Errorf("unexpected database file:\n%s\nwant:\n%s", data, want)
+	}
+	if e, err := a.Handler.ShrtFile.Get("foo"); err != nil || e.URL != "https://example.com/new" {
+		t.Errorf("change not reloaded: %+v, %v", e, err)
+	}
+}
+
+func TestAdminHandlerInvalidFile(t *testing.T) {
+	a, _ := newTestAdmin(t, "foo=shrtlnk:https://example.com/foo\n")
+	if err := os.WriteFile(a.Path, []byte("foo\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	w := adminRequest(a, http.MethodDelete, "/admin/links/foo", "")
+	if w.Code != http.StatusConflict || !strings.Contains(w.Body.String(), "line 1") {
+		t.Errorf("got status %d, want %d: %s", w.Code, http.StatusConflict, w.Body)
+	}
+}
+
+func TestCheckPassword(t *testing.T) {
+	tests := []struct {
+		hash, pass string
+	}{
+		// Generated with openssl passwd -apr1 -salt
+		{"$apr1$abcdefgh$h9FWgUz3n9YxylKLlR5SQ/", "secret"},
+		{"$apr1$12$ZEKRlNsP7zt8JdY.k6axJ/", "password"},
+		{"{SHA}5en6G6MezRroT3XKqkdPOmY/BfQ=", "secret"},
+		{"$2a$05$TCiU4MHkWUAtcuRASUYSpuOyZMkDq6p3aSyuJmtVnZZ.X5tGSVlA2", "secret"},
+	}
+	for _, tt := range tests {
+		if !checkPassword(tt.hash, tt.pass) {
+			t.Errorf("%s: password %q not accepted", tt.hash, tt.pass)
+		}
+		if checkPassword(tt.hash, tt.pass+"x") {
+			t.Errorf("%s: wrong password accepted", tt.hash)
+		}
+	}
+}
+
+func TestAuthenticateUnknownUser(t *testing.T) {
+	c := &Credentials{Users: map[string]string{"alice": "{SHA}5en6G6MezRroT3XKqkdPOmY/BfQ="}}
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.SetBasicAuth("bob", "secret")
+	if _, ok := c.Authenticate(req); ok {
+		t.Error("unknown user accepted")
+	}
+	// Unknown users must cost a full bcrypt comparison
+	if cost, err := bcrypt.Cost([]byte(unknownUserHash)); err != nil || cost != bcrypt.DefaultCost {
+		t.Errorf("unknownUserHash: got cost %d, %v", cost, err)
+	}
+}
+
+func TestAdminHandlerGeneratedKey(t *testing.T) {
+	a, _ := newTestAdmin(t, "")
+	a.Keys = &KeyGenerator{Alphabet: "xyz", Length: 4}
+	w := adminRequest(a, http.MethodPost, "/admin/links", `{"type":"shrtlnk","url":"https://example.com/"}`)
+	var e adminEntry
+	if err := json.NewDecoder(w.Body).Decode(&e); err != nil || w.Code != http.StatusCreated {
+		t.Fatalf("got status %d, %v", w.Code, err)
+	}
+	if len(e.Key) != 4 || strings.Trim(e.Key, "xyz") != "" {
+		t.Errorf("got key %q", e.Key)
+	}
+	if val, err := a.Handler.ShrtFile.Get(e.Key); err != nil || val.URL != "https://example.com/" {
+		t.Errorf("generated entry not served: %+v, %v", val, err)
+	}
+}
+
+func TestAdminHandlerDefaultType(t *testing.T) {
+	a, _ := newTestAdmin(t, "")
+	w := adminRequest(a, http.MethodPost, "/admin/links", `{"url":"https://example.com/"}`)
+	var e adminEntry
+	if err := json.NewDecoder(w.Body).Decode(&e); err != nil || w.Code != http.StatusCreated {
+		t.Fatalf("got status %d, %v", w.Code, err)
+	}
+	if e.Key == "" || e.Type != ShortLink {
+		t.Errorf("got entry %+v", e)
+	}
+	if val, err := a.Handler.ShrtFile.Get(e.Key); err != nil || val.Type != ShortLink {
+		t.Errorf("generated entry not served: %+v, %v", val, err)
+	}
+}
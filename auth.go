@@ -0,0 +1,196 @@
+// See LICENSE file for copyright and license details
+
+package shrt
+
+import (
+	"bufio"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Credentials authenticate requests to the administrative handlers.
+// A request is authenticated if it presents one of the bearer tokens
+// in an Authorization header, or the name and password of one of the
+// users via HTTP basic authentication.
+type Credentials struct {
+	// Tokens lists the accepted bearer tokens.
+	Tokens []string
+	// Users maps user names to password hashes in any format
+	// supported by [ReadHtpasswd].
+	Users map[string]string
+}
+
+// unknownUserHash is the bcrypt hash, at the default cost, against
+// which the passwords of unknown users are checked.
+const unknownUserHash = "$2a$10$TKb3im8xygsdUoic24WSD.dqcVI959mjlQzJEaPD8Pe.3ViDLKCKa"
+
+// Authenticate reports whether req carries valid credentials, along
+// with the name of the authenticated user. Requests authenticated by
+// token have the user name "token".
+func (c *Credentials) Authenticate(req *http.Request) (string, bool) {
+	if c == nil {
+		return "", false
+	}
+	if user, pass, ok := req.BasicAuth(); ok {
+		hash, ok := c.Users[user]
+		if !ok {
+			// Take as long as for a known user, so that the
+			// time taken does not reveal which users exist
+			checkPassword(unknownUserHash, pass)
+			return user, false
+		}
+		return user, checkPassword(hash, pass)
+	}
+	auth := req.Header.Get("Authorization")
+	if len(auth) < 7 || !strings.EqualFold(auth[:7], "Bearer ") {
+		return "", false
+	}
+	token := []byte(strings.TrimSpace(auth[7:]))
+	found := 0
+	for _, t := range c.Tokens {
+		found |= subtle.ConstantTimeCompare(token, []byte(t))
+	}
+	return "token", found == 1
+}
+
+// ReadTokens reads bearer tokens from r, one per line. Blank lines
+// and lines beginning with '#' are ignored.
+func ReadTokens(r io.Reader) ([]string, error) {
+	return readLines(r)
+}
+
+// readLines returns the lines read from r, less surrounding white
+// space, blank lines, and lines beginning with '#'.
+func readLines(r io.Reader) ([]string, error) {
+	var lines []string
+	scnr := bufio.NewScanner(r)
+	for scnr.Scan() {
+		if isComment(scnr.Text()) {
+			continue
+		}
+		lines = append(lines, strings.TrimSpace(scnr.Text()))
+	}
+	return lines, scnr.Err()
+}
+
+// ReadHtpasswd reads a password file in the format written by the
+// Apache htpasswd utility, and returns a map from user names to
+// password hashes. Hashes created with the -B (bcrypt), -m (the
+// default MD5-based format) and -s (SHA-1) options are supported.
+// Blank lines and lines beginning with '#' are ignored.
+func ReadHtpasswd(r io.Reader) (map[string]string, error) {
+	users := make(map[string]string)
+	scnr := bufio.NewScanner(r)
+	line := 0
+	for scnr.Scan() {
+		line++
+		if isComment(scnr.Text()) {
+			continue
+		}
+		tok := strings.SplitN(strings.TrimSpace(scnr.Text()), ":", 2)
+		if len(tok) != 2 || tok[0] == "" {
+			return nil, fmt.Errorf("line %d: invalid syntax", line)
+		}
+		if !supportedHash(tok[1]) {
+			return nil, fmt.Errorf("line %d: unsupported password hash for %s", line, tok[0])
+		}
+		users[tok[0]] = tok[1]
+	}
+	return users, scnr.Err()
+}
+
+func supportedHash(hash string) bool {
+	for _, prefix := range []string{"$2a$", "$2b$", "$2y$", "$apr1$", "{SHA}"} {
+		if strings.HasPrefix(hash, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkPassword reports whether pass matches the htpasswd hash.
+func checkPassword(hash, pass string) bool {
+	switch {
+	case strings.HasPrefix(hash, "$2"):
+		return bcrypt.CompareHashAndPassword([]byte(hash), []byte(pass)) == nil
+	case strings.HasPrefix(hash, "$apr1$"):
+		salt := strings.SplitN(hash[len("$apr1$"):], "$", 2)[0]
+		return subtle.ConstantTimeCompare([]byte(hash), []byte(apr1(pass, salt))) == 1
+	case strings.HasPrefix(hash, "{SHA}"):
+		sum := sha1.Sum([]byte(pass))
+		want := "{SHA}" + base64.StdEncoding.EncodeToString(sum[:])
+		return subtle.ConstantTimeCompare([]byte(hash), []byte(want)) == 1
+	}
+	return false
+}
+
+// apr1 returns the Apache variant of the MD5-based crypt(3) hash of
+// pass with the given salt.
+func apr1(pass, salt string) string {
+	const magic = "$apr1$"
+	if len(salt) > 8 {
+		salt = salt[:8]
+	}
+
+	alt := md5.Sum([]byte(pass + salt + pass))
+	h := md5.New()
+	io.WriteString(h, pass+magic+salt)
+	for i := len(pass); i > 0; i -= 16 {
+		if i > 16 {
+			h.Write(alt[:])
+		} else {
+			h.Write(alt[:i])
+		}
+	}
+	for i := len(pass); i > 0; i >>= 1 {
+		if i&1 == 1 {
+			h.Write([]byte{0})
+		} else {
+			h.Write([]byte{pass[0]})
+		}
+	}
+	sum := h.Sum(nil)
+
+	for i := 0; i < 1000; i++ {
+		h.Reset()
+		if i&1 == 1 {
+			io.WriteString(h, pass)
+		} else {
+			h.Write(sum)
+		}
+		if i%3 != 0 {
+			io.WriteString(h, salt)
+		}
+		if i%7 != 0 {
+			io.WriteString(h, pass)
+		}
+		if i&1 == 1 {
+			h.Write(sum)
+		} else {
+			io.WriteString(h, pass)
+		}
+		sum = h.Sum(nil)
+	}
+
+	const itoa64 = "./0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+	var out strings.Builder
+	encode := func(v uint, n int) {
+		for ; n > 0; n-- {
+			out.WriteByte(itoa64[v&0x3f])
+			v >>= 6
+		}
+	}
+	for _, idx := range [][3]int{{0, 6, 12}, {1, 7, 13}, {2, 8, 14}, {3, 9, 15}, {4, 10, 5}} {
+		encode(uint(sum[idx[0]])<<16|uint(sum[idx[1]])<<8|uint(sum[idx[2]]), 4)
+	}
+	encode(uint(sum[11]), 2)
+	return magic + salt + "$" + out.String()
+}
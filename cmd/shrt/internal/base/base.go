@@ -11,15 +11,57 @@ import (
 
 // Environment variable keys
 const (
-	SHRTENV           = "SHRTENV"
-	SHRT_SRVNAME      = "SHRT_SRVNAME"
-	SHRT_SCMTYPE      = "SHRT_SCMTYPE"
-	SHRT_SUFFIX       = "SHRT_SUFFIX"
-	SHRT_RDRNAME      = "SHRT_RDRNAME"
-	SHRT_BARERDR      = "SHRT_BARERDR"
-	SHRT_DBPATH       = "SHRT_DBPATH"
-	SHRT_GOSOURCEDIR  = "SHRT_GOSOURCEDIR"
-	SHRT_GOSOURCEFILE = "SHRT_GOSOURCEFILE"
+	SHRTENV             = "SHRTENV"
+	SHRT_SRVNAME        = "SHRT_SRVNAME"
+	SHRT_SCMTYPE        = "SHRT_SCMTYPE"
+	SHRT_SUFFIX         = "SHRT_SUFFIX"
+	SHRT_RDRNAME        = "SHRT_RDRNAME"
+	SHRT_BARERDR        = "SHRT_BARERDR"
+	SHRT_DBPATH         = "SHRT_DBPATH"
+	SHRT_GOSOURCEDIR    = "SHRT_GOSOURCEDIR"
+	SHRT_GOSOURCEFILE   = "SHRT_GOSOURCEFILE"
+	SHRT_CACHESHRTLNK   = "SHRT_CACHESHRTLNK"
+	SHRT_CACHEGOGET     = "SHRT_CACHEGOGET"
+	SHRT_CACHEBARERDR   = "SHRT_CACHEBARERDR"
+	SHRT_CACHENOTFOUND  = "SHRT_CACHENOTFOUND"
+	SHRT_ADMINADDR      = "SHRT_ADMINADDR"
+	SHRT_ADMINPREFIX    = "SHRT_ADMINPREFIX"
+	SHRT_ADMINTOKENS    = "SHRT_ADMINTOKENS"
+	SHRT_ADMINHTPASSWD  = "SHRT_ADMINHTPASSWD"
+	SHRT_UIPREFIX       = "SHRT_UIPREFIX"
+	SHRT_UISECRET       = "SHRT_UISECRET"
+	SHRT_PIDFILE        = "SHRT_PIDFILE"
+	SHRT_KEYALPHABET    = "SHRT_KEYALPHABET"
+	SHRT_KEYLENGTH      = "SHRT_KEYLENGTH"
+	SHRT_KEYSTRATEGY    = "SHRT_KEYSTRATEGY"
+	SHRT_KEYBLOCKLIST   = "SHRT_KEYBLOCKLIST"
+	SHRT_PREVIEW        = "SHRT_PREVIEW"
+	SHRT_TLSCERT        = "SHRT_TLSCERT"
+	SHRT_TLSKEY         = "SHRT_TLSKEY"
+	SHRT_TLSREDIRECT    = "SHRT_TLSREDIRECT"
+	SHRT_SOCKETMODE     = "SHRT_SOCKETMODE"
+	SHRT_SOCKETOWNER    = "SHRT_SOCKETOWNER"
+	SHRT_DRAINTIMEOUT   = "SHRT_DRAINTIMEOUT"
+	SHRT_READTIMEOUT    = "SHRT_READTIMEOUT"
+	SHRT_HEADERTIMEOUT  = "SHRT_HEADERTIMEOUT"
+	SHRT_WRITETIMEOUT   = "SHRT_WRITETIMEOUT"
+	SHRT_IDLETIMEOUT    = "SHRT_IDLETIMEOUT"
+	SHRT_MAXHEADERBYTES = "SHRT_MAXHEADERBYTES"
+	SHRT_MAXCONNS       = "SHRT_MAXCONNS"
+	SHRT_MAXPATHLEN     = "SHRT_MAXPATHLEN"
+	SHRT_LOCKDOWN       = "SHRT_LOCKDOWN"
+	SHRT_CHROOT         = "SHRT_CHROOT"
+	SHRT_USER           = "SHRT_USER"
+	SHRT_RATELIMIT      = "SHRT_RATELIMIT"
+	SHRT_RATELIMIT404   = "SHRT_RATELIMIT404"
+	SHRT_RATESUBNET     = "SHRT_RATESUBNET"
+	SHRT_RATEEXEMPT     = "SHRT_RATEEXEMPT"
+	SHRT_RATECLIENTS    = "SHRT_RATECLIENTS"
+	SHRT_RATESTATUS     = "SHRT_RATESTATUS"
+	SHRT_TRUSTEDPROXIES = "SHRT_TRUSTEDPROXIES"
+	SHRT_PROXYPROTOCOL  = "SHRT_PROXYPROTOCOL"
+	SHRT_CANONICALHOST  = "SHRT_CANONICALHOST"
+	SHRT_ALTHOSTS       = "SHRT_ALTHOSTS"
 )
 
 // KnownEnv is a list of environment variables that affect the
@@ -34,6 +76,48 @@ const KnownEnv = `
 	SHRT_DBPATH
 	SHRT_GOSOURCEDIR
 	SHRT_GOSOURCEFILE
+	SHRT_CACHESHRTLNK
+	SHRT_CACHEGOGET
+	SHRT_CACHEBARERDR
+	SHRT_CACHENOTFOUND
+	SHRT_ADMINADDR
+	SHRT_ADMINPREFIX
+	SHRT_ADMINTOKENS
+	SHRT_ADMINHTPASSWD
+	SHRT_UIPREFIX
+	SHRT_UISECRET
+	SHRT_PIDFILE
+	SHRT_KEYALPHABET
+	SHRT_KEYLENGTH
+	SHRT_KEYSTRATEGY
+	SHRT_KEYBLOCKLIST
+	SHRT_PREVIEW
+	SHRT_TLSCERT
+	SHRT_TLSKEY
+	SHRT_TLSREDIRECT
+	SHRT_SOCKETMODE
+	SHRT_SOCKETOWNER
+	SHRT_DRAINTIMEOUT
+	SHRT_READTIMEOUT
+	SHRT_HEADERTIMEOUT
+	SHRT_WRITETIMEOUT
+	SHRT_IDLETIMEOUT
+	SHRT_MAXHEADERBYTES
+	SHRT_MAXCONNS
+	SHRT_MAXPATHLEN
+	SHRT_LOCKDOWN
+	SHRT_CHROOT
+	SHRT_USER
+	SHRT_RATELIMIT
+	SHRT_RATELIMIT404
+	SHRT_RATESUBNET
+	SHRT_RATEEXEMPT
+	SHRT_RATECLIENTS
+	SHRT_RATESTATUS
+	SHRT_TRUSTEDPROXIES
+	SHRT_PROXYPROTOCOL
+	SHRT_CANONICALHOST
+	SHRT_ALTHOSTS
 	`
 
 type Command struct {
@@ -70,10 +154,11 @@ Shortlinks generate an HTTP 301 response. Go-get requests generate
 an HTTP 200 response. If configured, requests to the base path
 (i.e., "/") generate an HTTP 302 response.
 
-In order to add a new shortlink to the database, simply edit the
-file. After saving, users on Unix systems may send SIGHUP to a
-running server process to reload the file. Non-Unix users will need
-to restart the server.
+In order to add a new shortlink to the database, use 'shrt add' or
+simply edit the file. After saving, users on Unix systems may send
+SIGHUP to a running server process to reload the file. Alternatively,
+a server started with 'shrt serve -w' notices changes to the file and
+reloads it automatically on any system.
 `,
 	Usage: "shrt <command> [arguments]",
 }
@@ -0,0 +1,129 @@
+// See LICENSE file for copyright and license details
+
+// Package check implements the "shrt check" command
+package check
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+
+	"djmo.ch/go-shrt"
+	"djmo.ch/go-shrt/cmd/shrt/internal/base"
+)
+
+var Cmd = &base.Command{
+	Name:      "check",
+	Usage:     "shrt check [-strict] [file]",
+	ShortHelp: "check the configuration and database",
+	LongHelp: `Check validates the configuration and database without serving.
+
+The configuration is read from SHRTENV and the process environment,
+as for every command, and then validated as 'shrt serve' would
+validate it. Errors in the SHRTENV file are reported with their line
+numbers before any command runs.
+
+The database at SHRT_DBPATH, or the given file, is then parsed and
+every error is reported with its line number. Valid databases are
+also checked for entries that are probably mistakes, and a warning is
+printed for each one:
+
+  - URLs that do not use the http or https scheme
+  - keys that conflict with paths reserved by the server, such as
+    /robots.txt, /.shrt/status, SHRT_ADMINPREFIX and SHRT_UIPREFIX
+  - entries with the same URL as an earlier entry
+  - goget URLs that look like repositories of a type other than
+    SHRT_SCMTYPE
+
+Check exits with a non-zero status if it finds any error, or, with
+the -strict flag, any warning. This makes it suitable for use in a
+pre-commit hook.
+	`,
+}
+
+var checkStrict = Cmd.Flags.Bool("strict", false, "")
+
+func init() {
+	// break init cycle
+	Cmd.Run = runCheck
+}
+
+func runCheck(ctx context.Context) {
+	var (
+		args = ctx.Value("args").([]string)
+		cfg  = ctx.Value("cfg").(shrt.Config)
+		w    = ctx.Value("w").(io.Writer)
+	)
+	if len(args) > 1 {
+		log.Fatal("usage: ", Cmd.Usage)
+	}
+	path := filepath.FromSlash("/" + cfg.DbPath)
+	if len(args) == 1 {
+		path = args[0]
+	}
+	if !check(w, cfg, path, reserved()) {
+		os.Exit(1)
+	}
+}
+
+// check reports the problems with cfg and the database at path to w,
+// and reports whether they pass.
+func check(w io.Writer, cfg shrt.Config, path string, reserved []string) bool {
+	ok := true
+	if err := cfg.Validate(); err != nil {
+		fmt.Fprintln(w, "configuration error:", err)
+		ok = false
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		fmt.Fprintln(w, err)
+		return false
+	}
+	defer f.Close()
+	warnings, err := shrt.Lint(f, cfg, reserved...)
+	var list shrt.ErrorList
+	switch {
+	case errors.As(err, &list):
+		for _, e := range list {
+			fmt.Fprintf(w, "%s:%d: %s\n", path, e.Line, e.Msg)
+		}
+		fmt.Fprintf(w, "%s: %d errors\n", path, len(list))
+		return false
+	case err != nil:
+		fmt.Fprintf(w, "%s: %s\n", path, err)
+		return false
+	}
+	for _, warn := range warnings {
+		fmt.Fprintf(w, "%s:%d: warning: %s: %s\n", path, warn.Line, warn.Key, warn.Msg)
+	}
+	if len(warnings) > 0 {
+		fmt.Fprintf(w, "%s: %d warnings\n", path, len(warnings))
+		if *checkStrict {
+			ok = false
+		}
+	}
+	if ok {
+		fmt.Fprintf(w, "%s: ok\n", path)
+	}
+	return ok
+}
+
+// reserved returns the request paths served on the main listener by
+// handlers other than the ShrtHandler.
+func reserved() []string {
+	if os.Getenv(base.SHRT_ADMINADDR) != "" {
+		return nil
+	}
+	var paths []string
+	for _, key := range []string{base.SHRT_ADMINPREFIX, base.SHRT_UIPREFIX} {
+		if p := os.Getenv(key); p != "" {
+			paths = append(paths, p)
+		}
+	}
+	return paths
+}
@@ -0,0 +1,40 @@
+// See LICENSE file for copyright and license details
+
+package check
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"djmo.ch/go-shrt"
+)
+
+func TestCheck(t *testing.T) {
+	dir := t.TempDir()
+	cfg := shrt.Config{SrvName: "example.org", ScmType: "git", DbPath: "shrt.db"}
+	tests := []struct {
+		db     string
+		strict bool
+		ok     bool
+		output string
+	}{
+		{"foo=shrtlnk:https://example.com/foo\n", false, true, "shrt.db: ok\n"},
+		{"foo=shrtlnk:ftp://example.com/foo\n", false, true, "shrt.db:1: warning: foo: URL scheme"},
+		{"foo=shrtlnk:ftp://example.com/foo\n", true, false, "shrt.db: 1 warnings\n"},
+		{"foo\nbar=nope:https://example.com/\n", false, false, "shrt.db:2: unrecognized type: nope\n"},
+	}
+	for _, tt := range tests {
+		path := filepath.Join(dir, "shrt.db")
+		if err := os.WriteFile(path, []byte(tt.db), 0644); err != nil {
+			t.Fatal(err)
+		}
+		*checkStrict = tt.strict
+		b := new(strings.Builder)
+		if ok := check(b, cfg, path, nil); ok != tt.ok || !strings.Contains(b.String(), tt.output) {
+			t.Errorf("%q (strict %v): got %v and output:\n%s\nwant %v and %q", tt.db, tt.strict, ok, b, tt.ok, tt.output)
+		}
+	}
+	*checkStrict = false
+}
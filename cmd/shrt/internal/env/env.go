@@ -14,6 +14,7 @@ import (
 	"log"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 
 	"djmo.ch/go-shrt"
@@ -21,13 +22,52 @@ import (
 )
 
 const (
-	srvNameDefault      = "example.com"
-	scmTypeDefault      = "git"
-	suffixDefault       = ".git"
-	rdrNameDefault      = "github.com/user"
-	bareRdrDefault      = "example.org"
-	goSourceDirDefault  = ""
-	goSourceFileDefault = ""
+	srvNameDefault        = "example.com"
+	scmTypeDefault        = "git"
+	suffixDefault         = ".git"
+	rdrNameDefault        = "github.com/user"
+	bareRdrDefault        = "example.org"
+	goSourceDirDefault    = ""
+	goSourceFileDefault   = ""
+	cacheDefault          = ""
+	adminAddrDefault      = ""
+	adminPrefixDefault    = ""
+	adminTokensDefault    = ""
+	adminHtpasswdDefault  = ""
+	uiPrefixDefault       = ""
+	uiSecretDefault       = ""
+	pidFileDefault        = ""
+	keyAlphabetDefault    = shrt.DefaultKeyAlphabet
+	keyLengthDefault      = "6"
+	keyStrategyDefault    = "random"
+	keyBlocklistDefault   = ""
+	previewDefault        = "off"
+	tlsCertDefault        = ""
+	tlsKeyDefault         = ""
+	tlsRedirectDefault    = ""
+	socketModeDefault     = "0660"
+	socketOwnerDefault    = ""
+	drainTimeoutDefault   = "10s"
+	readTimeoutDefault    = "15s"
+	headerTimeoutDefault  = "5s"
+	writeTimeoutDefault   = "30s"
+	idleTimeoutDefault    = "120s"
+	maxHeaderBytesDefault = "32768"
+	maxConnsDefault       = "1024"
+	maxPathLenDefault     = "1024"
+	lockdownDefault       = "on"
+	chrootDefault         = ""
+	userDefault           = ""
+	rateLimitDefault      = ""
+	rateLimit404Default   = ""
+	rateSubnetDefault     = "32,64"
+	rateExemptDefault     = ""
+	rateClientsDefault    = "10000"
+	rateStatusDefault     = "429"
+	trustedProxiesDefault = ""
+	proxyProtocolDefault  = "off"
+	canonicalHostDefault  = "off"
+	altHostsDefault       = ""
 )
 
 var Cmd = &base.Command{
@@ -182,69 +222,284 @@ func writeEnvFile(path string, envMap map[string]string) {
 // ConfigFromEnv returns a Config object matching the current
 // environment.
 func ConfigFromEnv() shrt.Config {
+	return configFrom(os.LookupEnv)
+}
+
+func configFrom(lookup func(string) (string, bool)) shrt.Config {
+	get := func(key, d string) string {
+		if v, ok := lookup(key); ok {
+			return v
+		}
+		return d
+	}
 	return shrt.Config{
-		SrvName: envOrDefault(base.SHRT_SRVNAME, srvNameDefault),
-		ScmType: envOrDefault(base.SHRT_SCMTYPE, scmTypeDefault),
-		Suffix:  envOrDefault(base.SHRT_SUFFIX, suffixDefault),
-		RdrName: envOrDefault(base.SHRT_RDRNAME, rdrNameDefault),
-		BareRdr: envOrDefault(base.SHRT_BARERDR, bareRdrDefault),
+		SrvName: get(base.SHRT_SRVNAME, srvNameDefault),
+		ScmType: get(base.SHRT_SCMTYPE, scmTypeDefault),
+		Suffix:  get(base.SHRT_SUFFIX, suffixDefault),
+		RdrName: get(base.SHRT_RDRNAME, rdrNameDefault),
+		BareRdr: get(base.SHRT_BARERDR, bareRdrDefault),
 		// Trim the leading / to satisfy fs.FS
-		DbPath:       strings.TrimPrefix(envOrDefault(base.SHRT_DBPATH, dbPathDefault), "/"),
-		GoSourceDir:  envOrDefault(base.SHRT_GOSOURCEDIR, goSourceDirDefault),
-		GoSourceFile: envOrDefault(base.SHRT_GOSOURCEFILE, goSourceFileDefault),
+		DbPath:       strings.TrimPrefix(get(base.SHRT_DBPATH, dbPathDefault), "/"),
+		GoSourceDir:  get(base.SHRT_GOSOURCEDIR, goSourceDirDefault),
+		GoSourceFile: get(base.SHRT_GOSOURCEFILE, goSourceFileDefault),
+
+		CacheShortLink: get(base.SHRT_CACHESHRTLNK, cacheDefault),
+		CacheGoGet:     get(base.SHRT_CACHEGOGET, cacheDefault),
+		CacheBareRdr:   get(base.SHRT_CACHEBARERDR, cacheDefault),
+		CacheNotFound:  get(base.SHRT_CACHENOTFOUND, cacheDefault),
+
+		Preview: Enabled(get(base.SHRT_PREVIEW, previewDefault)),
+
+		CanonicalHost: Enabled(get(base.SHRT_CANONICALHOST, canonicalHostDefault)),
+		AltHosts:      strings.Fields(strings.ReplaceAll(get(base.SHRT_ALTHOSTS, altHostsDefault), ",", " ")),
+	}
+}
+
+// KeyGeneratorFromEnv returns a KeyGenerator matching the current
+// environment.
+func KeyGeneratorFromEnv() (*shrt.KeyGenerator, error) {
+	g := &shrt.KeyGenerator{Alphabet: os.Getenv(base.SHRT_KEYALPHABET)}
+	length, err := strconv.Atoi(os.Getenv(base.SHRT_KEYLENGTH))
+	if err != nil {
+		return nil, fmt.Errorf("%s: not a number: %s", base.SHRT_KEYLENGTH, os.Getenv(base.SHRT_KEYLENGTH))
+	}
+	g.Length = length
+	switch s := os.Getenv(base.SHRT_KEYSTRATEGY); s {
+	case "random":
+	case "hash":
+		g.Hash = true
+	default:
+		return nil, fmt.Errorf("%s: unknown strategy: %s", base.SHRT_KEYSTRATEGY, s)
+	}
+	if path := os.Getenv(base.SHRT_KEYBLOCKLIST); path != "" {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		if g.Blocklist, err = shrt.ReadBlocklist(f); err != nil {
+			return nil, fmt.Errorf("%s: %s", path, err)
+		}
+	}
+	if err := g.Check(); err != nil {
+		return nil, err
 	}
+	return g, nil
+}
+
+// ErrorList is a list of the errors found in the SHRTENV file, each
+// prefixed with the file name and line number.
+type ErrorList []string
+
+func (l ErrorList) Error() string {
+	return strings.Join(l, "\n")
+}
+
+// Enabled reports whether the value of a switch variable turns it
+// on. Besides on, the values accepted by strconv.ParseBool are
+// recognized.
+func Enabled(v string) bool {
+	on, _ := strconv.ParseBool(v)
+	return on || v == "on"
 }
 
+// osEnv holds the known environment variables that were set in the
+// process environment before MergeEnv first ran. They take
+// precedence over SHRTENV every time the environment is merged.
+var osEnv map[string]string
+
 // MergeEnv merges the program's environment with that specified in
 // SHRTENV. Values already specified in the environment take
 // precedence.
 func MergeEnv() {
-	envPath := envOrDefault(base.SHRTENV, envDefault)
+	env, err := mergedEnv()
+	if errs, ok := err.(ErrorList); ok {
+		for _, e := range errs {
+			log.Println(e)
+		}
+		os.Exit(1)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	for k, v := range env {
+		os.Setenv(k, v)
+	}
+}
+
+// mergedEnv returns the values of all known environment variables,
+// taken from the process environment, SHRTENV, or the defaults, in
+// that order of precedence.
+func mergedEnv() (map[string]string, error) {
+	if osEnv == nil {
+		osEnv = make(map[string]string)
+		for _, key := range strings.Fields(base.KnownEnv) {
+			if v, ok := os.LookupEnv(key); ok {
+				osEnv[key] = v
+			}
+		}
+	}
+	env := make(map[string]string)
+	for k, v := range osEnv {
+		env[k] = v
+	}
+
+	envPath, ok := env[base.SHRTENV]
+	if !ok {
+		envPath = envDefault
+	}
 	envFile, err := os.ReadFile(envPath)
 	if err != nil {
 		if !errors.Is(err, fs.ErrNotExist) {
-			log.Fatalf("error reading %s: %s", envPath, err)
+			return nil, fmt.Errorf("error reading %s: %s", envPath, err)
 		}
 		envFile = []byte{}
 	}
 
 	// Read envfile into environment
+	var errs ErrorList
 	s := bufio.NewScanner(bytes.NewReader(envFile))
-	for s.Scan() {
+	for line := 1; s.Scan(); line++ {
 		kv := strings.SplitN(s.Text(), "=", 2)
 		if len(kv) == 1 {
-			log.Fatal("malformed line in SHRTENV: ", s.Text())
+			errs = append(errs, fmt.Sprintf("%s:%d: malformed line: %s", envPath, line, s.Text()))
+			continue
 		}
 
 		key := kv[0]
 		if !strings.Contains(base.KnownEnv, key) {
-			log.Fatal("unknown env var: ", key)
+			errs = append(errs, fmt.Sprintf("%s:%d: unknown env var: %s", envPath, line, key))
+			continue
 		}
 		value := kv[1]
 
-		if _, ok := os.LookupEnv(key); !ok {
-			os.Setenv(key, value)
+		if _, ok := env[key]; !ok {
+			env[key] = value
 		}
 	}
+	if len(errs) > 0 {
+		return nil, errs
+	}
 
 	defaults := map[string]string{
-		base.SHRTENV:           envDefault,
-		base.SHRT_SRVNAME:      srvNameDefault,
-		base.SHRT_SCMTYPE:      scmTypeDefault,
-		base.SHRT_SUFFIX:       suffixDefault,
-		base.SHRT_RDRNAME:      rdrNameDefault,
-		base.SHRT_BARERDR:      bareRdrDefault,
-		base.SHRT_DBPATH:       dbPathDefault,
-		base.SHRT_GOSOURCEDIR:  goSourceDirDefault,
-		base.SHRT_GOSOURCEFILE: goSourceFileDefault,
+		base.SHRTENV:             envDefault,
+		base.SHRT_SRVNAME:        srvNameDefault,
+		base.SHRT_SCMTYPE:        scmTypeDefault,
+		base.SHRT_SUFFIX:         suffixDefault,
+		base.SHRT_RDRNAME:        rdrNameDefault,
+		base.SHRT_BARERDR:        bareRdrDefault,
+		base.SHRT_DBPATH:         dbPathDefault,
+		base.SHRT_GOSOURCEDIR:    goSourceDirDefault,
+		base.SHRT_GOSOURCEFILE:   goSourceFileDefault,
+		base.SHRT_CACHESHRTLNK:   cacheDefault,
+		base.SHRT_CACHEGOGET:     cacheDefault,
+		base.SHRT_CACHEBARERDR:   cacheDefault,
+		base.SHRT_CACHENOTFOUND:  cacheDefault,
+		base.SHRT_ADMINADDR:      adminAddrDefault,
+		base.SHRT_ADMINPREFIX:    adminPrefixDefault,
+		base.SHRT_ADMINTOKENS:    adminTokensDefault,
+		base.SHRT_ADMINHTPASSWD:  adminHtpasswdDefault,
+		base.SHRT_UIPREFIX:       uiPrefixDefault,
+		base.SHRT_UISECRET:       uiSecretDefault,
+		base.SHRT_PIDFILE:        pidFileDefault,
+		base.SHRT_KEYALPHABET:    keyAlphabetDefault,
+		base.SHRT_KEYLENGTH:      keyLengthDefault,
+		base.SHRT_KEYSTRATEGY:    keyStrategyDefault,
+		base.SHRT_KEYBLOCKLIST:   keyBlocklistDefault,
+		base.SHRT_PREVIEW:        previewDefault,
+		base.SHRT_TLSCERT:        tlsCertDefault,
+		base.SHRT_TLSKEY:         tlsKeyDefault,
+		base.SHRT_TLSREDIRECT:    tlsRedirectDefault,
+		base.SHRT_SOCKETMODE:     socketModeDefault,
+		base.SHRT_SOCKETOWNER:    socketOwnerDefault,
+		base.SHRT_DRAINTIMEOUT:   drainTimeoutDefault,
+		base.SHRT_READTIMEOUT:    readTimeoutDefault,
+		base.SHRT_HEADERTIMEOUT:  headerTimeoutDefault,
+		base.SHRT_WRITETIMEOUT:   writeTimeoutDefault,
+		base.SHRT_IDLETIMEOUT:    idleTimeoutDefault,
+		base.SHRT_MAXHEADERBYTES: maxHeaderBytesDefault,
+		base.SHRT_MAXCONNS:       maxConnsDefault,
+		base.SHRT_MAXPATHLEN:     maxPathLenDefault,
+		base.SHRT_LOCKDOWN:       lockdownDefault,
+		base.SHRT_CHROOT:         chrootDefault,
+		base.SHRT_USER:           userDefault,
+		base.SHRT_RATELIMIT:      rateLimitDefault,
+		base.SHRT_RATELIMIT404:   rateLimit404Default,
+		base.SHRT_RATESUBNET:     rateSubnetDefault,
+		base.SHRT_RATEEXEMPT:     rateExemptDefault,
+		base.SHRT_RATECLIENTS:    rateClientsDefault,
+		base.SHRT_RATESTATUS:     rateStatusDefault,
+		base.SHRT_TRUSTEDPROXIES: trustedProxiesDefault,
+		base.SHRT_PROXYPROTOCOL:  proxyProtocolDefault,
+		base.SHRT_CANONICALHOST:  canonicalHostDefault,
+		base.SHRT_ALTHOSTS:       altHostsDefault,
 	}
 
 	// Populate missing environment variables with defaults
 	for _, key := range strings.Fields(base.KnownEnv) {
-		if _, ok := os.LookupEnv(key); !ok {
-			os.Setenv(key, envOrDefault(key, defaults[key]))
+		if _, ok := env[key]; !ok {
+			env[key] = defaults[key]
+		}
+	}
+	return env, nil
+}
+
+// ReloadConfig merges the environment again, as MergeEnv does, and
+// returns the resulting Config along with the names of the variables
+// whose values changed. The variables named in fixed keep their
+// current values, and a warning is logged for each one that would
+// have changed. If SHRTENV cannot be read or the resulting Config is
+// invalid, an error is returned and the environment is left as it
+// was.
+func ReloadConfig(fixed ...string) (shrt.Config, []string, error) {
+	env, err := mergedEnv()
+	if err != nil {
+		return shrt.Config{}, nil, err
+	}
+
+	var changed []string
+	for _, key := range strings.Fields(base.KnownEnv) {
+		cur := os.Getenv(key)
+		if env[key] == cur {
+			continue
+		}
+		if isFixed(key, fixed) {
+			log.Printf("%s cannot change while running ... ignoring", key)
+			env[key] = cur
+			continue
+		}
+		changed = append(changed, key)
+	}
+
+	cfg := configFrom(func(key string) (string, bool) {
+		v, ok := env[key]
+		return v, ok
+	})
+	if err := cfg.Validate(); err != nil {
+		return shrt.Config{}, nil, err
+	}
+	for k, v := range env {
+		os.Setenv(k, v)
+	}
+	return cfg, changed, nil
+}
+
+// SetEnvFile changes the value of SHRTENV, and so the file read by
+// later calls to ReloadConfig, to path.
+func SetEnvFile(path string) {
+	os.Setenv(base.SHRTENV, path)
+	if osEnv != nil {
+		osEnv[base.SHRTENV] = path
+	}
+}
+
+func isFixed(key string, fixed []string) bool {
+	for _, f := range fixed {
+		if key == f {
+			return true
 		}
 	}
+	return false
 }
 
 func envOrDefault(key, d string) string {
@@ -6,6 +6,7 @@ import (
 	"bufio"
 	"context"
 	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 
@@ -73,3 +74,42 @@ func clearEnv() {
 		os.Unsetenv(envVar)
 	}
 }
+
+func TestReloadConfig(t *testing.T) {
+	clearEnv()
+	osEnv = nil
+	envPath := filepath.Join(t.TempDir(), "env")
+	os.Setenv(base.SHRTENV, envPath)
+	os.Setenv(base.SHRT_SCMTYPE, "hg")
+	writeEnvFile(envPath, map[string]string{base.SHRT_SRVNAME: "foo"})
+	MergeEnv()
+
+	writeEnvFile(envPath, map[string]string{
+		base.SHRT_SRVNAME: "bar",
+		base.SHRT_SCMTYPE: "git",
+		base.SHRT_DBPATH:  "/baz",
+	})
+	cfg, changed, err := ReloadConfig(base.SHRT_DBPATH)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changed) != 1 || changed[0] != base.SHRT_SRVNAME {
+		t.Errorf("unexpected changed variables: %v", changed)
+	}
+	if cfg.SrvName != "bar" || cfg.ScmType != "hg" {
+		t.Errorf("unexpected config: %+v", cfg)
+	}
+	if cfg.DbPath != strings.TrimPrefix(dbPathDefault, "/") {
+		t.Errorf("fixed variable changed: %s", cfg.DbPath)
+	}
+
+	writeEnvFile(envPath, map[string]string{base.SHRT_SRVNAME: ""})
+	if _, _, err := ReloadConfig(); err == nil {
+		t.Error("expected error for invalid config")
+	}
+	if os.Getenv(base.SHRT_SRVNAME) != "bar" {
+		t.Error("environment changed by failed reload")
+	}
+	clearEnv()
+	osEnv = nil
+}
@@ -48,5 +48,268 @@ Environment variables:
 		to form the file entry in the go-source meta tag. This
 		key is experimental and may be removed in a future
 		release.
+	SHRT_CACHESHRTLNK
+		The Cache-Control header value sent with shortlink
+		redirects. If empty, no Cache-Control header is sent.
+	SHRT_CACHEGOGET
+		The Cache-Control header value sent with go-get
+		responses. If empty, no Cache-Control header is sent.
+	SHRT_CACHEBARERDR
+		The Cache-Control header value sent with redirects
+		from the base path. If empty, no Cache-Control header
+		is sent.
+	SHRT_CACHENOTFOUND
+		The Cache-Control header value sent with not found
+		responses. If empty, no Cache-Control header is sent.
+	SHRT_ADMINADDR
+		The URL on which to serve the admin API, for example
+		http://127.0.0.1:8081. If empty, and SHRT_ADMINPREFIX
+		is set, the admin API is served on the main listener.
+	SHRT_ADMINPREFIX
+		The path prefix at which the admin API is served, for
+		example /.shrt/admin. It may be the root only if
+		SHRT_ADMINADDR is set. If both SHRT_ADMINADDR and
+		SHRT_ADMINPREFIX are empty, the admin API is disabled.
+	SHRT_ADMINTOKENS
+		The absolute path to a file of bearer tokens, one per
+		line, accepted by the admin API.
+	SHRT_ADMINHTPASSWD
+		The absolute path to an htpasswd file of users
+		accepted by the admin API via HTTP basic
+		authentication. Passwords hashed with bcrypt, MD5 or
+		SHA-1 are supported.
+	SHRT_UIPREFIX
+		The path prefix at which the web UI for browsing and
+		editing links is served, for example /.shrt/ui. The UI
+		accepts the users in SHRT_ADMINHTPASSWD and is served
+		on the admin listener if SHRT_ADMINADDR is set. If
+		empty, the web UI is disabled.
+	SHRT_UISECRET
+		The absolute path to a file holding the key, of at
+		least 16 bytes, with which the web UI signs the
+		tokens that protect its forms from cross-site request
+		forgery. If empty, a random key is chosen each time
+		shrt serve starts, so forms left open across a
+		restart must be reloaded. Required for the web UI in
+		cgi mode.
+	SHRT_PIDFILE
+		The absolute path of a file to which shrt serve writes
+		its process ID at startup, for use by the -r flag of
+		shrt add and shrt rm. If empty, no file is written.
+	SHRT_KEYALPHABET
+		The characters from which keys are generated for links
+		added without one.
+	SHRT_KEYLENGTH
+		The length of generated keys.
+	SHRT_KEYSTRATEGY
+		How keys are generated: random, or hash to derive
+		each key from a hash of the URL.
+	SHRT_KEYBLOCKLIST
+		The absolute path to a file of strings, one per line,
+		that never appear in generated keys.
+	SHRT_PREVIEW
+		Set to on to serve a preview page describing each
+		entry at /key+ and /key?preview, instead of
+		redirecting.
+	SHRT_TLSCERT
+		The absolute path to the PEM-encoded certificate chain
+		served when shrt serve listens on an https URL. The
+		certificate is reloaded on SIGHUP.
+	SHRT_TLSKEY
+		The absolute path to the PEM-encoded private key for
+		SHRT_TLSCERT. The key is reloaded with the
+		certificate.
+	SHRT_TLSREDIRECT
+		The URL of a plain http listener that redirects every
+		request to the https URL on which shrt serve listens,
+		for example http://:80. If empty, no such listener is
+		started.
+	SHRT_SOCKETMODE
+		The permissions, in octal, of the Unix domain sockets
+		on which shrt serve listens when given a unix URL.
+	SHRT_SOCKETOWNER
+		The owner of the Unix domain sockets on which shrt
+		serve listens, as user, user:group or :group. Names
+		and numeric IDs are accepted. If empty, the owner is
+		left unchanged.
+	SHRT_DRAINTIMEOUT
+		How long shrt serve waits for requests in progress to
+		complete when it is stopped with SIGINT or SIGTERM,
+		for example 30s. The server stops accepting
+		connections, drains, removes the Unix domain sockets
+		it created and SHRT_PIDFILE, and exits with status
+		zero only if every request completed. A second signal
+		makes it exit at once.
+	SHRT_READTIMEOUT
+		The longest time shrt serve allows for reading a
+		request, including its body. 0 means no limit.
+	SHRT_HEADERTIMEOUT
+		The longest time shrt serve allows for reading the
+		headers of a request. 0 means no limit.
+	SHRT_WRITETIMEOUT
+		The longest time shrt serve allows for writing a
+		response, measured from the end of the request
+		headers. 0 means no limit.
+	SHRT_IDLETIMEOUT
+		How long shrt serve keeps an idle keep-alive
+		connection open. 0 means no limit.
+	SHRT_MAXHEADERBYTES
+		The largest size, in bytes, of the headers of a
+		request accepted by shrt serve.
+	SHRT_MAXCONNS
+		The largest number of connections shrt serve accepts
+		at once on each listener. Further connections wait
+		until one closes. 0 means no limit.
+	SHRT_MAXPATHLEN
+		The longest request path, in bytes, accepted by shrt
+		serve. Longer paths are refused with status 414. 0
+		means no limit.
+	SHRT_LOCKDOWN
+		Set to off to disable the sandbox that shrt serve
+		enters once it is ready to serve, which limits it to
+		reading the database, SHRTENV and the TLS files, and
+		to writing next to the database if the admin API or
+		web UI is enabled. On OpenBSD, the sandbox uses
+		unveil(2) and pledge(2). On Linux, it uses Landlock,
+		which requires Linux 5.13, and, on amd64 and arm64,
+		a seccomp filter allowing only the system calls the
+		server makes. Parts of the sandbox that the kernel
+		does not support are skipped with a warning. Landlock
+		cannot be used by a build with cgo, so on Linux such
+		a build refuses to serve unless this is off; build
+		shrt with CGO_ENABLED=0 to avoid that.
+	SHRT_CHROOT
+		A directory to which shrt serve, started as root,
+		changes its root before loading any files. Every
+		other path, such as SHRT_DBPATH, SHRT_TLSCERT,
+		SHRT_PIDFILE and the paths of Unix domain sockets, then
+		names a file inside it. SHRTENV is reread on SIGHUP
+		only if it is inside SHRT_CHROOT.
+	SHRT_USER
+		The user, as user or user:group, that shrt serve
+		switches to once its listeners are bound, for example
+		www:www. If no group is given, the user's primary
+		group is used. The files reread on SIGHUP must be
+		readable by that user, and the directories holding
+		the database, sockets and pidfile writable by it
+		where the server modifies them.
+	SHRT_RATELIMIT
+		The number of requests shrt serve answers for each
+		client, as n/s, n/m or n/h, for example 120/m, apart
+		from those answered with 404 Not Found. Clients may
+		use the whole budget in a burst. Empty or 0 means no
+		limit. The admin API and web UI are not limited, nor
+		are clients on Unix domain sockets whose addresses
+		are not given by a trusted proxy.
+	SHRT_RATELIMIT404
+		The number of requests answered with 404 Not Found
+		that shrt serve allows each client, in the form of
+		SHRT_RATELIMIT, for example 20/m. The two budgets are
+		separate: once one is spent, only the requests that
+		would draw on it are refused until it is replenished.
+		Empty or 0 means no limit.
+	SHRT_RATESUBNET
+		The prefix lengths of the IPv4 and IPv6 subnets
+		treated as a single client by the rate limits,
+		separated by a comma.
+	SHRT_RATEEXEMPT
+		A comma-separated list of addresses and subnets in
+		CIDR notation, such as 192.0.2.0/24, which are exempt
+		from the rate limits.
+	SHRT_RATECLIENTS
+		The largest number of clients whose rate limits are
+		tracked at once. When more are seen, the least
+		recently seen client is forgotten.
+	SHRT_RATESTATUS
+		The status with which shrt serve refuses requests
+		over the rate limits. A Retry-After header gives the
+		number of seconds until the next request is allowed.
+	SHRT_TRUSTEDPROXIES
+		A comma-separated list of the addresses and subnets,
+		in CIDR notation, of the reverse proxies and load
+		balancers in front of shrt serve, whose Forwarded,
+		X-Forwarded-For and X-Forwarded-Proto headers give
+		the address and scheme of the original client. The
+		word unix trusts peers on Unix domain sockets.
+	SHRT_PROXYPROTOCOL
+		Set to on if the trusted proxies in
+		SHRT_TRUSTEDPROXIES begin each connection with a
+		PROXY protocol header, version 1 or 2, as sent by
+		HAProxy.
+	SHRT_CANONICALHOST
+		Set to on to serve only requests sent to SHRT_SRVNAME,
+		so that go-get responses are never served under
+		another name. Requests sent to SHRT_ALTHOSTS are
+		redirected to the same path on SHRT_SRVNAME, and
+		requests sent to any other host are refused with
+		421 Misdirected Request. Redirects keep the scheme and
+		port of the request, so a proxy that terminates TLS
+		must be listed in SHRT_TRUSTEDPROXIES for them to
+		keep https.
+	SHRT_ALTHOSTS
+		A comma- or space-separated list of host names and
+		addresses, without ports, such as old domains and
+		www. variants, whose requests are redirected to
+		SHRT_SRVNAME when SHRT_CANONICALHOST is on.
+`,
+}
+
+var URLsCmd = &base.Command{
+	Name:      "urls",
+	ShortHelp: "listen URLs",
+	LongHelp: `
+The URL on which shrt serve listens, and those in SHRT_ADMINADDR and
+SHRT_TLSREDIRECT, take one of the following forms.
+
+	http://HOST:PORT
+		Plain HTTP on a TCP address.
+	https://HOST:PORT
+		HTTPS on a TCP address, with the certificate chain in
+		SHRT_TLSCERT and the key in SHRT_TLSKEY. TLS versions
+		before 1.2 are refused. If SHRT_TLSREDIRECT is set, a
+		plain http listener is also started at that URL,
+		which redirects every request to the https URL.
+	unix:///PATH
+		Plain HTTP on a Unix domain socket at the absolute
+		PATH, for use behind a local reverse proxy. The socket
+		is given the permissions in SHRT_SOCKETMODE and, if
+		set, the owner in SHRT_SOCKETOWNER. A stale socket
+		left by a server that did not exit cleanly is removed
+		at startup, but a socket on which another server is
+		listening, or a file that is not a socket, is an
+		error. The socket is removed when the server exits.
+	fcgi://HOST:PORT
+	fcgi:///PATH
+		FastCGI requests from a web server such as httpd(8)
+		or lighttpd, on a TCP address or on a Unix domain
+		socket managed as above. The timeouts, header limit
+		and PROXY protocol do not apply, since the clients
+		are handled by the web server.
+	SCHEME+systemd://NAME
+		A socket passed by systemd socket activation
+		(LISTEN_FDS), serving SCHEME, which is http, https or
+		fcgi. NAME is the name set with FileDescriptorName=,
+		as in https+systemd://web, and may be omitted if only
+		one socket is passed.
+	SCHEME+fd://N
+		The inherited open file descriptor N, serving SCHEME,
+		as in http+fd://0 for a server started by inetd in
+		wait mode.
+
+Inherited sockets allow restarts without dropping connections and
+binding to privileged ports without running as root. They are not
+removed when the server exits.
+
+In place of a URL, shrt serve accepts the word cgi, which handles the
+single CGI request described by the environment and exits. The
+database is read once, and only the response requested is rendered.
+SHRT_PIDFILE is ignored, and the -w flag, SHRT_ADMINADDR, SHRT_CHROOT
+and SHRT_USER cannot be used; the admin API and web UI are available
+beneath SHRT_ADMINPREFIX and SHRT_UIPREFIX as usual, but the web UI
+requires SHRT_UISECRET.
+
+For FastCGI and CGI, requests are routed by their full path, so the
+server must handle every path on the site rather than being mounted
+beneath a script path.
 `,
 }
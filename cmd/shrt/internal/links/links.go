@@ -0,0 +1,297 @@
+// See LICENSE file for copyright and license details
+
+// Package links implements the "shrt add", "shrt rm", "shrt ls",
+// "shrt show" and "shrt qr" commands
+package links
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"text/tabwriter"
+
+	"djmo.ch/go-shrt"
+	"djmo.ch/go-shrt/cmd/shrt/internal/base"
+	"djmo.ch/go-shrt/cmd/shrt/internal/env"
+)
+
+const reloadHelp = `
+The -r flag asks a running server to reload the database once the
+change is made, by sending SIGHUP to the process whose ID is recorded
+in SHRT_PIDFILE. This is only supported on Unix systems. A server
+started with 'shrt serve -w' reloads the database without it.
+`
+
+var AddCmd = &base.Command{
+	Name:      "add",
+	Usage:     "shrt add [-f] [-r] [KEY TYPE] URL",
+	ShortHelp: "add a link to the database",
+	LongHelp: `Add adds an entry to the database.
+
+TYPE is shrtlnk for a shortlink, or goget for a go-get redirect. If
+only URL is given, a shortlink is added with a generated key, which
+is printed. Keys are generated as configured by SHRT_KEYALPHABET,
+SHRT_KEYLENGTH, SHRT_KEYSTRATEGY and SHRT_KEYBLOCKLIST, and never
+collide with an existing key. The entry is appended to the end of
+SHRT_DBPATH. Comments and existing entries are left untouched. The
+database is locked while it is changed, and the new file is written
+to a temporary file that is then renamed into place, so a running
+server never reads a partial file.
+
+If KEY already exists, add fails unless the -f flag is given, in
+which case the existing entry is replaced in place.
+` + reloadHelp,
+}
+
+var RmCmd = &base.Command{
+	Name:      "rm",
+	Usage:     "shrt rm [-r] KEY...",
+	ShortHelp: "remove links from the database",
+	LongHelp: `Rm removes entries from the database.
+
+The comment lines directly above each entry are removed with it. If
+any KEY does not exist, rm fails without changing the database. The
+database is locked and replaced as described in 'shrt help add'.
+` + reloadHelp,
+}
+
+var LsCmd = &base.Command{
+	Name:      "ls",
+	Usage:     "shrt ls [-type TYPE] [-json] [pattern]",
+	ShortHelp: "list links in the database",
+	LongHelp: `Ls lists the entries in the database, sorted by key.
+
+If pattern is given, only keys matching it are listed. The pattern
+syntax is that of path.Match, for example 'go-*'. The -type flag
+lists only entries of the given type, shrtlnk or goget.
+
+By default, each entry is printed on a line with its key, type and
+URL separated by spaces. The -json flag prints a JSON array of
+objects with "key", "type" and "url" members instead.
+	`,
+}
+
+var ShowCmd = &base.Command{
+	Name:      "show",
+	Usage:     "shrt show KEY",
+	ShortHelp: "show a link in the database",
+	LongHelp: `Show prints the entry for KEY and the address at which it is
+served.
+
+Show exits with a non-zero status if KEY does not exist.
+	`,
+}
+
+var (
+	addF   = AddCmd.Flags.Bool("f", false, "")
+	addR   = AddCmd.Flags.Bool("r", false, "")
+	rmR    = RmCmd.Flags.Bool("r", false, "")
+	lsType = LsCmd.Flags.String("type", "", "")
+	lsJSON = LsCmd.Flags.Bool("json", false, "")
+)
+
+var errExists = errors.New("key already exists (use -f to replace it)")
+
+func init() {
+	// break init cycle
+	AddCmd.Run = runAdd
+	RmCmd.Run = runRm
+	LsCmd.Run = runLs
+	ShowCmd.Run = runShow
+}
+
+type entry struct {
+	Key  string        `json:"key"`
+	Type shrt.ShrtType `json:"type"`
+	URL  string        `json:"url"`
+}
+
+func runAdd(ctx context.Context) {
+	var (
+		args = ctx.Value("args").([]string)
+		cfg  = ctx.Value("cfg").(shrt.Config)
+		w    = ctx.Value("w").(io.Writer)
+	)
+	var (
+		key  string
+		val  shrt.ShrtEntry
+		keys *shrt.KeyGenerator
+		err  error
+	)
+	switch len(args) {
+	case 1:
+		val = shrt.ShrtEntry{Type: shrt.ShortLink, URL: args[0]}
+		if keys, err = env.KeyGeneratorFromEnv(); err != nil {
+			log.Fatal(err)
+		}
+	case 3:
+		typ, err := shrt.ParseShrtType(args[1])
+		if err != nil {
+			log.Fatal(err)
+		}
+		key, val = args[0], shrt.ShrtEntry{Type: typ, URL: args[2]}
+	default:
+		log.Fatal("usage: ", AddCmd.Usage)
+	}
+	err = shrt.EditShrtFile(dbPath(cfg), func(ed *shrt.Editor) error {
+		if keys != nil {
+			k, err := keys.Generate(val.URL, func(k string) bool {
+				_, ok := ed.Get(k)
+				return ok
+			})
+			if err != nil {
+				return err
+			}
+			key = k
+		} else if _, ok := ed.Get(key); ok && !*addF {
+			return errExists
+		}
+		return ed.Set(key, val)
+	})
+	if err != nil {
+		fatalDbError(cfg, err)
+	}
+	if keys != nil {
+		fmt.Fprintf(w, "%s/%s\n", cfg.SrvName, key)
+	}
+	if *addR {
+		signalReload()
+	}
+}
+
+func runRm(ctx context.Context) {
+	var (
+		args = ctx.Value("args").([]string)
+		cfg  = ctx.Value("cfg").(shrt.Config)
+	)
+	if len(args) == 0 {
+		log.Fatal("usage: ", RmCmd.Usage)
+	}
+	err := shrt.EditShrtFile(dbPath(cfg), func(ed *shrt.Editor) error {
+		for _, key := range args {
+			if !ed.Delete(key) {
+				return fmt.Errorf("no such key: %s", key)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		fatalDbError(cfg, err)
+	}
+	if *rmR {
+		signalReload()
+	}
+}
+
+func runLs(ctx context.Context) {
+	var (
+		args = ctx.Value("args").([]string)
+		cfg  = ctx.Value("cfg").(shrt.Config)
+		w    = ctx.Value("w").(io.Writer)
+
+		pattern = "*"
+		typ     shrt.ShrtType
+	)
+	switch len(args) {
+	case 0:
+	case 1:
+		pattern = args[0]
+		if _, err := path.Match(pattern, ""); err != nil {
+			log.Fatal("invalid pattern: ", pattern)
+		}
+	default:
+		log.Fatal("usage: ", LsCmd.Usage)
+	}
+	if *lsType != "" {
+		var err error
+		if typ, err = shrt.ParseShrtType(*lsType); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	snap := readDb(cfg)
+	entries := []entry{}
+	for _, key := range snap.Keys() {
+		val, _ := snap.Get(key)
+		if ok, _ := path.Match(pattern, key); !ok {
+			continue
+		}
+		if typ != shrt.NoneType && val.Type != typ {
+			continue
+		}
+		entries = append(entries, entry{Key: key, Type: val.Type, URL: val.URL})
+	}
+
+	if *lsJSON {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "\t")
+		if err := enc.Encode(entries); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	tw := tabwriter.NewWriter(w, 0, 8, 1, ' ', 0)
+	for _, e := range entries {
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", e.Key, e.Type, e.URL)
+	}
+	tw.Flush()
+}
+
+func runShow(ctx context.Context) {
+	var (
+		args = ctx.Value("args").([]string)
+		cfg  = ctx.Value("cfg").(shrt.Config)
+		w    = ctx.Value("w").(io.Writer)
+	)
+	if len(args) != 1 {
+		log.Fatal("usage: ", ShowCmd.Usage)
+	}
+	val, err := readDb(cfg).Get(args[0])
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Fprintln(w, "key:    ", args[0])
+	fmt.Fprintln(w, "type:   ", val.Type)
+	fmt.Fprintln(w, "url:    ", val.URL)
+	fmt.Fprintf(w, "served:  %s/%s\n", cfg.SrvName, args[0])
+}
+
+// dbPath returns the operating system path of the database.
+func dbPath(cfg shrt.Config) string {
+	return filepath.FromSlash("/" + cfg.DbPath)
+}
+
+// readDb reads the database, and exits if it cannot be read or
+// contains errors.
+func readDb(cfg shrt.Config) *shrt.Snapshot {
+	f, err := os.Open(dbPath(cfg))
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer f.Close()
+	shrtfile := shrt.NewShrtFile()
+	if err := shrtfile.ReadShrtFile(f); err != nil {
+		fatalDbError(cfg, err)
+	}
+	return shrtfile.Snapshot()
+}
+
+// fatalDbError logs err, which was returned while reading or editing
+// the database, and exits. Each error in a shrt.ErrorList is logged
+// separately.
+func fatalDbError(cfg shrt.Config, err error) {
+	var list shrt.ErrorList
+	if errors.As(err, &list) {
+		for _, e := range list {
+			log.Printf("%s: %s", dbPath(cfg), e)
+		}
+		os.Exit(1)
+	}
+	log.Fatal(err)
+}
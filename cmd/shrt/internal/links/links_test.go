@@ -0,0 +1,95 @@
+// See LICENSE file for copyright and license details
+
+package links
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"djmo.ch/go-shrt"
+)
+
+func run(t *testing.T, cfg shrt.Config, fn func(context.Context), args ...string) string {
+	t.Helper()
+	b := new(strings.Builder)
+	ctx := context.Background()
+	ctx = context.WithValue(ctx, "args", args)
+	ctx = context.WithValue(ctx, "w", b)
+	ctx = context.WithValue(ctx, "cfg", cfg)
+	fn(ctx)
+	return b.String()
+}
+
+func TestLinks(t *testing.T) {
+	db := filepath.Join(t.TempDir(), "shrt.db")
+	if err := os.WriteFile(db, []byte("# Home\nfoo=shrtlnk:https://example.com/foo\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cfg := shrt.Config{SrvName: "example.org", DbPath: strings.TrimPrefix(filepath.ToSlash(db), "/")}
+
+	run(t, cfg, runAdd, "go-bar", "goget", "https://git.example.com/bar")
+	run(t, cfg, runAdd, "go-baz", "goget", "https://git.example.com/baz")
+	*addF = true
+	run(t, cfg, runAdd, "foo", "shrtlnk", "https://example.com/new")
+	*addF = false
+	run(t, cfg, runRm, "go-baz")
+
+	t.Setenv("SHRT_KEYALPHABET", "xyz")
+	t.Setenv("SHRT_KEYLENGTH", "4")
+	t.Setenv("SHRT_KEYSTRATEGY", "hash")
+	out := run(t, cfg, runAdd, "https://example.com/gen")
+	key := strings.TrimSuffix(strings.TrimPrefix(out, "example.org/"), "\n")
+	if len(key) != 4 || strings.Trim(key, "xyz") != "" {
+		t.Fatalf("add URL: got output %q", out)
+	}
+	run(t, cfg, runRm, key)
+
+	want := "foo    shrtlnk https://example.com/new\n" +
+		"go-bar goget   https://git.example.com/bar\n"
+	if got := run(t, cfg, runLs); got != want {
+		t.Errorf("ls:\n%s\nwant:\n%s", got, want)
+	}
+	*lsType = "goget"
+	if got := run(t, cfg, runLs, "go-*"); !strings.HasPrefix(got, "go-bar ") || strings.Count(got, "\n") != 1 {
+		t.Errorf("ls -type goget go-*:\n%s", got)
+	}
+	*lsType, *lsJSON = "", true
+	if got := run(t, cfg, runLs, "f*"); !strings.Contains(got, `"url": "https://example.com/new"`) {
+		t.Errorf("ls -json f*:\n%s", got)
+	}
+	*lsJSON = false
+	if got := run(t, cfg, runShow, "go-bar"); !strings.Contains(got, "served:  example.org/go-bar\n") {
+		t.Errorf("show go-bar:\n%s", got)
+	}
+
+	data, err := os.ReadFile(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = "# Home\nfoo=shrtlnk:https://example.com/new\ngo-bar=goget:https://git.example.com/bar\n"
+	if string(data) != want {
+		t.Errorf("unexpected database file:\n%s\nwant:\n%s", data, want)
+	}
+}
+
+func TestQR(t *testing.T) {
+	db := filepath.Join(t.TempDir(), "shrt.db")
+	if err := os.WriteFile(db, []byte("foo=shrtlnk:https://example.com/foo\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cfg := shrt.Config{SrvName: "example.org", DbPath: strings.TrimPrefix(filepath.ToSlash(db), "/")}
+
+	*qrSVG, *qrEC = true, "Q"
+	defer func() { *qrSVG, *qrEC = false, shrt.DefaultQRLevel }()
+	want := new(strings.Builder)
+	opts := shrt.QROptions{Size: shrt.DefaultQRSize, Level: "Q", Format: "svg"}
+	if err := shrt.WriteQRCode(want, "https://example.org/foo", opts); err != nil {
+		t.Fatal(err)
+	}
+	if got := run(t, cfg, runQR, "foo"); got != want.String() {
+		t.Errorf("qr -svg -ec Q foo:\n%s\nwant:\n%s", got, want)
+	}
+}
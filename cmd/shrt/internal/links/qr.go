@@ -0,0 +1,84 @@
+// See LICENSE file for copyright and license details
+
+package links
+
+import (
+	"context"
+	"io"
+	"log"
+	"os"
+
+	"djmo.ch/go-shrt"
+	"djmo.ch/go-shrt/cmd/shrt/internal/base"
+)
+
+var QRCmd = &base.Command{
+	Name:      "qr",
+	Usage:     "shrt qr [-size n] [-ec level] [-svg] [-o file] KEY",
+	ShortHelp: "write the QR code for a link",
+	LongHelp: `Qr writes a QR code encoding the short URL of KEY,
+https://SHRT_SRVNAME/KEY. The code is generated locally, and is the
+same as the one served at /KEY.qr by 'shrt serve' with the same
+parameters.
+
+The -size flag sets the width and height of the image in pixels
+(default 256), up to 4096; 'shrt serve' serves sizes up to 1024 only.
+The -ec flag sets the error correction level: L, M, Q or H,
+recovering about 7%, 15%, 25% and 30% of a damaged code respectively
+(default M). The -svg flag writes an SVG image instead of a PNG
+image.
+
+The image is written to standard output, or to the file named by the
+-o flag.
+
+Qr exits with a non-zero status if KEY does not exist.
+	`,
+}
+
+var (
+	qrSize = QRCmd.Flags.Int("size", shrt.DefaultQRSize, "")
+	qrEC   = QRCmd.Flags.String("ec", shrt.DefaultQRLevel, "")
+	qrSVG  = QRCmd.Flags.Bool("svg", false, "")
+	qrOut  = QRCmd.Flags.String("o", "", "")
+)
+
+func init() {
+	// break init cycle
+	QRCmd.Run = runQR
+}
+
+func runQR(ctx context.Context) {
+	var (
+		args = ctx.Value("args").([]string)
+		cfg  = ctx.Value("cfg").(shrt.Config)
+		w    = ctx.Value("w").(io.Writer)
+	)
+	if len(args) != 1 {
+		log.Fatal("usage: ", QRCmd.Usage)
+	}
+	if _, err := readDb(cfg).Get(args[0]); err != nil {
+		log.Fatal(err)
+	}
+	opts := shrt.QROptions{Size: *qrSize, Level: *qrEC, Format: "png"}
+	if *qrSVG {
+		opts.Format = "svg"
+	}
+	if *qrOut == "" {
+		if err := shrt.WriteQRCode(w, cfg.ShortURL(args[0]), opts); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	f, err := os.Create(*qrOut)
+	if err != nil {
+		log.Fatal(err)
+	}
+	err = shrt.WriteQRCode(f, cfg.ShortURL(args[0]), opts)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(*qrOut)
+		log.Fatal(err)
+	}
+}
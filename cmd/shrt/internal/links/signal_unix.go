@@ -0,0 +1,35 @@
+// See LICENSE file for copyright and license details
+
+//go:build unix
+
+package links
+
+import (
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"djmo.ch/go-shrt/cmd/shrt/internal/base"
+)
+
+// signalReload sends SIGHUP to the server whose process ID is
+// recorded in SHRT_PIDFILE.
+func signalReload() {
+	pidfile := os.Getenv(base.SHRT_PIDFILE)
+	if pidfile == "" {
+		log.Fatal("cannot signal server: ", base.SHRT_PIDFILE, " is not set")
+	}
+	data, err := os.ReadFile(pidfile)
+	if err != nil {
+		log.Fatal("cannot signal server: ", err)
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil || pid <= 0 {
+		log.Fatalf("cannot signal server: %s: invalid process ID", pidfile)
+	}
+	if err := syscall.Kill(pid, syscall.SIGHUP); err != nil {
+		log.Fatalf("cannot signal server (pid %d): %s", pid, err)
+	}
+}
@@ -0,0 +1,10 @@
+// See LICENSE file for copyright and license details
+
+package links
+
+import "log"
+
+// signalReload exits with an error, since Windows has no SIGHUP.
+func signalReload() {
+	log.Fatal("cannot signal server: not supported on this system; use 'shrt serve -w'")
+}
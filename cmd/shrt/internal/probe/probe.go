@@ -0,0 +1,356 @@
+// See LICENSE file for copyright and license details
+
+// Package probe implements the "shrt probe" command
+package probe
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+	"unicode"
+
+	"djmo.ch/go-shrt"
+	"djmo.ch/go-shrt/cmd/shrt/internal/base"
+)
+
+var Cmd = &base.Command{
+	Name:      "probe",
+	Usage:     "shrt probe [-j n] [-timeout d] [-json] [-annotate] [pattern]",
+	ShortHelp: "check that link targets are alive",
+	LongHelp: `Probe checks that the targets of the entries in the database are
+alive.
+
+Shortlink targets are requested with HEAD, falling back to GET if the
+HEAD request fails. Go-get targets are requested at the discovery
+endpoint of the repository type given by SHRT_SCMTYPE, for example
+info/refs for git, or the @v/list endpoint of the module for mod.
+A target is alive if the final response, after following up to 10
+redirects, has a 2xx status. Targets whose URLs do not use the http
+or https schemes are skipped.
+
+If pattern is given, only keys matching it are probed. The pattern
+syntax is that of path.Match.
+
+The -j flag sets the number of targets probed at once (default 8).
+The -timeout flag sets the time allowed for each request, including
+redirects (default 10s).
+
+By default, the results are printed as a table, with the redirects
+followed for each target listed beneath it, and a summary. The -json
+flag prints a JSON array of results instead.
+
+The -annotate flag records the result in the database. A comment of
+the form
+
+	# probe: dead as of 2006-01-02: 404 Not Found
+
+is added above each dead entry, replacing the one left by a previous
+probe, and removed from entries that are alive again. Other comments
+are left untouched. These comments are not part of the description
+shown on the preview page of the entry. The database is locked and
+replaced as described in 'shrt help add'.
+
+Probe exits with a non-zero status if any target is dead.
+	`,
+}
+
+var (
+	probeJ        = Cmd.Flags.Int("j", 8, "")
+	probeTimeout  = Cmd.Flags.Duration("timeout", 10*time.Second, "")
+	probeJSON     = Cmd.Flags.Bool("json", false, "")
+	probeAnnotate = Cmd.Flags.Bool("annotate", false, "")
+)
+
+// annotationTag begins the comments added by the -annotate flag.
+const annotationTag = "probe:"
+
+// maxRedirects is the number of redirects followed for each target.
+const maxRedirects = 10
+
+func init() {
+	// break init cycle
+	Cmd.Run = runProbe
+}
+
+// A result is the outcome of probing one entry.
+type result struct {
+	Key       string        `json:"key"`
+	Type      shrt.ShrtType `json:"type"`
+	URL       string        `json:"url"`
+	Method    string        `json:"method,omitempty"`
+	ProbeURL  string        `json:"probe_url,omitempty"`
+	OK        bool          `json:"ok"`
+	Skipped   bool          `json:"skipped,omitempty"`
+	Status    int           `json:"status,omitempty"`
+	Error     string        `json:"error,omitempty"`
+	Redirects []string      `json:"redirects,omitempty"`
+	Elapsed   float64       `json:"elapsed_seconds"`
+}
+
+// dead reports whether the probe found the target to be dead.
+func (r *result) dead() bool {
+	return !r.OK && !r.Skipped
+}
+
+// summary describes the outcome of the probe in a few words.
+func (r *result) summary() string {
+	switch {
+	case r.Skipped:
+		return "skipped: " + r.Error
+	case r.Error != "":
+		return "error: " + r.Error
+	}
+	return fmt.Sprintf("%d %s", r.Status, http.StatusText(r.Status))
+}
+
+func runProbe(ctx context.Context) {
+	var (
+		args = ctx.Value("args").([]string)
+		cfg  = ctx.Value("cfg").(shrt.Config)
+		w    = ctx.Value("w").(io.Writer)
+
+		pattern = "*"
+	)
+	switch len(args) {
+	case 0:
+	case 1:
+		pattern = args[0]
+		if _, err := path.Match(pattern, ""); err != nil {
+			log.Fatal("invalid pattern: ", pattern)
+		}
+	default:
+		log.Fatal("usage: ", Cmd.Usage)
+	}
+	if *probeJ < 1 {
+		log.Fatal("-j must be at least 1")
+	}
+
+	f, err := os.Open(dbPath(cfg))
+	if err != nil {
+		log.Fatal(err)
+	}
+	shrtfile := shrt.NewShrtFile()
+	err = shrtfile.ReadShrtFile(f)
+	f.Close()
+	if err != nil {
+		log.Fatalf("%s: %s", dbPath(cfg), err)
+	}
+	snap := shrtfile.Snapshot()
+
+	var results []*result
+	for _, key := range snap.Keys() {
+		if ok, _ := path.Match(pattern, key); ok {
+			val, _ := snap.Get(key)
+			results = append(results, &result{Key: key, Type: val.Type, URL: val.URL})
+		}
+	}
+
+	p := &prober{
+		cfg:    cfg,
+		client: &http.Client{Timeout: *probeTimeout},
+	}
+	p.probeAll(ctx, results, *probeJ)
+
+	if *probeJSON {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "\t")
+		if err := enc.Encode(results); err != nil {
+			log.Fatal(err)
+		}
+	} else {
+		printTable(w, results)
+	}
+
+	if *probeAnnotate {
+		if err := annotate(dbPath(cfg), results); err != nil {
+			log.Fatal(err)
+		}
+	}
+	for _, r := range results {
+		if r.dead() {
+			os.Exit(1)
+		}
+	}
+}
+
+// printTable prints results as a table, followed by a summary.
+func printTable(w io.Writer, results []*result) {
+	var dead, skipped int
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "KEY\tTYPE\tRESULT\tURL")
+	for _, r := range results {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", r.Key, r.Type, r.summary(), r.URL)
+		for _, u := range r.Redirects {
+			fmt.Fprintf(tw, "\t\t\t-> %s\n", u)
+		}
+		switch {
+		case r.Skipped:
+			skipped++
+		case r.dead():
+			dead++
+		}
+	}
+	tw.Flush()
+	fmt.Fprintf(w, "\n%d probed, %d dead, %d skipped\n", len(results), dead, skipped)
+}
+
+// annotate records the results in the database at path.
+func annotate(path string, results []*result) error {
+	today := time.Now().Format("2006-01-02")
+	return shrt.EditShrtFile(path, func(ed *shrt.Editor) error {
+		for _, r := range results {
+			if r.Skipped {
+				continue
+			}
+			if val, ok := ed.Get(r.Key); !ok || val.URL != r.URL {
+				continue // changed since it was probed
+			}
+			text := ""
+			if r.dead() {
+				text = fmt.Sprintf("dead as of %s: %s", today, r.summary())
+			}
+			ed.Annotate(r.Key, annotationTag, text)
+		}
+		return nil
+	})
+}
+
+// A prober probes link targets.
+type prober struct {
+	cfg    shrt.Config
+	client *http.Client
+}
+
+// probeAll probes the targets of results, n at a time, and records
+// the outcomes in results.
+func (p *prober) probeAll(ctx context.Context, results []*result, n int) {
+	var (
+		wg  sync.WaitGroup
+		sem = make(chan struct{}, n)
+	)
+	for _, r := range results {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(r *result) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			start := time.Now()
+			p.probe(ctx, r)
+			r.Elapsed = time.Since(start).Seconds()
+		}(r)
+	}
+	wg.Wait()
+}
+
+// probe probes the target of r.
+func (p *prober) probe(ctx context.Context, r *result) {
+	u, err := url.Parse(r.URL)
+	if err == nil && u.Scheme != "http" && u.Scheme != "https" {
+		err = fmt.Errorf("unsupported scheme %q", u.Scheme)
+	}
+	if err != nil {
+		r.Skipped = true
+		r.Error = err.Error()
+		return
+	}
+	if r.Type == shrt.ShortLink {
+		p.do(ctx, r, http.MethodHead, r.URL)
+		if !r.OK {
+			p.do(ctx, r, http.MethodGet, r.URL)
+		}
+		return
+	}
+	p.do(ctx, r, http.MethodGet, discoveryURL(p.cfg, r.Key, u))
+}
+
+// do makes a request and records the outcome in r, replacing the
+// outcome of any earlier request.
+func (p *prober) do(ctx context.Context, r *result, method, target string) {
+	r.Method, r.ProbeURL = method, target
+	r.OK, r.Status, r.Error, r.Redirects = false, 0, "", nil
+
+	client := *p.client
+	client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		if len(via) > maxRedirects {
+			return fmt.Errorf("stopped after %d redirects", maxRedirects)
+		}
+		r.Redirects = append(r.Redirects, req.URL.String())
+		return nil
+	}
+	req, err := http.NewRequestWithContext(ctx, method, target, nil)
+	if err != nil {
+		r.Error = err.Error()
+		return
+	}
+	req.Header.Set("User-Agent", "shrt-probe")
+	rsp, err := client.Do(req)
+	if err != nil {
+		var uerr *url.Error
+		if errors.As(err, &uerr) {
+			err = uerr.Err
+		}
+		r.Error = err.Error()
+		return
+	}
+	// Drain some of the body so the connection can be reused
+	io.CopyN(io.Discard, rsp.Body, 64<<10)
+	rsp.Body.Close()
+	r.Status = rsp.StatusCode
+	r.OK = rsp.StatusCode >= 200 && rsp.StatusCode < 300
+}
+
+// discoveryURL returns the URL at which the repository at u, served
+// as the go-get entry for key, is probed.
+func discoveryURL(cfg shrt.Config, key string, u *url.URL) string {
+	d := *u
+	d.Path = strings.TrimSuffix(d.Path, "/")
+	q := d.Query()
+	switch cfg.ScmType {
+	case "git":
+		d.Path += "/info/refs"
+		q.Set("service", "git-upload-pack")
+	case "hg":
+		q.Set("cmd", "capabilities")
+	case "bzr":
+		d.Path += "/.bzr/branch-format"
+	case "mod":
+		d.Path += "/" + escapePath(cfg.SrvName+"/"+key) + "/@v/list"
+		d.RawPath = d.Path // keep the exclamation marks
+	default:
+		return u.String()
+	}
+	d.RawQuery = q.Encode()
+	return d.String()
+}
+
+// escapePath escapes a module path for use in module proxy requests,
+// replacing each upper-case letter with an exclamation mark followed
+// by the letter's lower-case equivalent.
+func escapePath(p string) string {
+	var b strings.Builder
+	for _, r := range p {
+		if unicode.IsUpper(r) {
+			b.WriteByte('!')
+			r = unicode.ToLower(r)
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// dbPath returns the operating system path of the database.
+func dbPath(cfg shrt.Config) string {
+	return filepath.FromSlash("/" + cfg.DbPath)
+}
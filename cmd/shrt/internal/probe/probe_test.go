@@ -0,0 +1,107 @@
+// See LICENSE file for copyright and license details
+
+package probe
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"djmo.ch/go-shrt"
+)
+
+func TestProbe(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		switch req.URL.Path {
+		case "/old":
+			http.Redirect(w, req, "/new", http.StatusMovedPermanently)
+		case "/new":
+		case "/nohead":
+			if req.Method == http.MethodHead {
+				w.WriteHeader(http.StatusMethodNotAllowed)
+			}
+		case "/repo/info/refs":
+			if req.URL.Query().Get("service") != "git-upload-pack" {
+				w.WriteHeader(http.StatusBadRequest)
+			}
+		default:
+			http.NotFound(w, req)
+		}
+	}))
+	defer srv.Close()
+
+	results := []*result{
+		{Key: "old", Type: shrt.ShortLink, URL: srv.URL + "/old"},
+		{Key: "nohead", Type: shrt.ShortLink, URL: srv.URL + "/nohead"},
+		{Key: "gone", Type: shrt.ShortLink, URL: srv.URL + "/gone"},
+		{Key: "mail", Type: shrt.ShortLink, URL: "mailto:me@example.com"},
+		{Key: "repo", Type: shrt.GoGet, URL: srv.URL + "/repo"},
+		{Key: "norepo", Type: shrt.GoGet, URL: srv.URL + "/norepo"},
+	}
+	p := &prober{
+		cfg:    shrt.Config{SrvName: "example.org", ScmType: "git"},
+		client: &http.Client{Timeout: 5 * time.Second},
+	}
+	p.probeAll(context.Background(), results, 2)
+
+	want := []string{
+		"200 OK",
+		"200 OK",
+		"404 Not Found",
+		`skipped: unsupported scheme "mailto"`,
+		"200 OK",
+		"404 Not Found",
+	}
+	for i, r := range results {
+		if r.summary() != want[i] {
+			t.Errorf("%s: got %q, want %q", r.Key, r.summary(), want[i])
+		}
+	}
+	if len(results[0].Redirects) != 1 || results[0].Redirects[0] != srv.URL+"/new" {
+		t.Errorf("old: got redirects %v", results[0].Redirects)
+	}
+
+	db := filepath.Join(t.TempDir(), "shrt.db")
+	data := "# Old home\n# probe: dead as of 2000-01-01: 404 Not Found\nold=shrtlnk:" + srv.URL + "/old\n" +
+		"gone=shrtlnk:" + srv.URL + "/gone\n"
+	if err := os.WriteFile(db, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := annotate(db, results); err != nil {
+		t.Fatal(err)
+	}
+	got, err := os.ReadFile(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	today := time.Now().Format("2006-01-02")
+	want2 := "# Old home\nold=shrtlnk:" + srv.URL + "/old\n" +
+		"# probe: dead as of " + today + ": 404 Not Found\ngone=shrtlnk:" + srv.URL + "/gone\n"
+	if string(got) != want2 {
+		t.Errorf("annotated database:\n%s\nwant:\n%s", got, want2)
+	}
+}
+
+func TestDiscoveryURL(t *testing.T) {
+	tests := []struct {
+		scm, url, want string
+	}{
+		{"git", "https://example.com/repo/", "https://example.com/repo/info/refs?service=git-upload-pack"},
+		{"hg", "https://hg.example.com/repo", "https://hg.example.com/repo?cmd=capabilities"},
+		{"bzr", "https://example.com/repo", "https://example.com/repo/.bzr/branch-format"},
+		{"mod", "https://proxy.example.com", "https://proxy.example.com/example.org/!my!mod/@v/list"},
+		{"svn", "https://svn.example.com/repo", "https://svn.example.com/repo"},
+	}
+	for _, tt := range tests {
+		cfg := shrt.Config{SrvName: "example.org", ScmType: tt.scm}
+		u, _ := url.Parse(tt.url)
+		if got := discoveryURL(cfg, "MyMod", u); got != tt.want {
+			t.Errorf("%s %s: got %s, want %s", tt.scm, tt.url, got, tt.want)
+		}
+	}
+}
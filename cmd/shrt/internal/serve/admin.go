@@ -0,0 +1,135 @@
+// See LICENSE file for copyright and license details
+
+package serve
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"djmo.ch/go-shrt"
+	"djmo.ch/go-shrt/cmd/shrt/internal/base"
+	"djmo.ch/go-shrt/cmd/shrt/internal/env"
+)
+
+// minSecretLen is the length of the shortest key accepted in
+// SHRT_UISECRET.
+const minSecretLen = 16
+
+// adminHandlers returns the admin API and web UI handlers configured
+// in the environment. Either is nil if it is disabled.
+func adminHandlers(h *shrt.ShrtHandler) (*shrt.AdminHandler, *shrt.UIHandler, error) {
+	var (
+		addr     = os.Getenv(base.SHRT_ADMINADDR)
+		prefix   = os.Getenv(base.SHRT_ADMINPREFIX)
+		uiPrefix = os.Getenv(base.SHRT_UIPREFIX)
+	)
+	if addr == "" && prefix == "" && uiPrefix == "" {
+		return nil, nil, nil
+	}
+	creds, err := readCredentials()
+	if err != nil {
+		return nil, nil, err
+	}
+	keys, err := env.KeyGeneratorFromEnv()
+	if err != nil {
+		return nil, nil, err
+	}
+	var (
+		path   = filepath.FromSlash("/" + h.Config.DbPath)
+		reload = func() error { return reload(h) }
+		admin  *shrt.AdminHandler
+		ui     *shrt.UIHandler
+	)
+	if addr != "" || prefix != "" {
+		if addr == "" && cleanPrefix(prefix) == "" {
+			return nil, nil, errors.New(base.SHRT_ADMINPREFIX + " must not be the root unless " +
+				base.SHRT_ADMINADDR + " is set")
+		}
+		admin = &shrt.AdminHandler{
+			Handler:     h,
+			Path:        path,
+			Prefix:      cleanPrefix(prefix),
+			Credentials: creds,
+			Reload:      reload,
+			Keys:        keys,
+		}
+	}
+	if uiPrefix != "" {
+		if cleanPrefix(uiPrefix) == "" {
+			return nil, nil, errors.New(base.SHRT_UIPREFIX + " must not be the root")
+		}
+		if len(creds.Users) == 0 {
+			return nil, nil, errors.New("ui: " + base.SHRT_ADMINHTPASSWD + " must be set")
+		}
+		secret, err := readSecret()
+		if err != nil {
+			return nil, nil, err
+		}
+		ui = &shrt.UIHandler{
+			Handler:     h,
+			Path:        path,
+			Prefix:      cleanPrefix(uiPrefix),
+			Credentials: creds,
+			Reload:      reload,
+			Keys:        keys,
+			Secret:      secret,
+		}
+	}
+	return admin, ui, nil
+}
+
+// readSecret reads the key with which the web UI signs its CSRF
+// tokens from the file named in the environment. It returns nil if
+// none is named.
+func readSecret() ([]byte, error) {
+	path := os.Getenv(base.SHRT_UISECRET)
+	if path == "" {
+		return nil, nil
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if b = bytes.TrimSpace(b); len(b) < minSecretLen {
+		return nil, fmt.Errorf("%s: key must be at least %d bytes", path, minSecretLen)
+	}
+	return b, nil
+}
+
+// readCredentials reads the credentials for the administrative
+// handlers from the files named in the environment.
+func readCredentials() (*shrt.Credentials, error) {
+	var (
+		creds    = new(shrt.Credentials)
+		tokens   = os.Getenv(base.SHRT_ADMINTOKENS)
+		htpasswd = os.Getenv(base.SHRT_ADMINHTPASSWD)
+	)
+	if tokens == "" && htpasswd == "" {
+		return nil, errors.New("admin: " + base.SHRT_ADMINTOKENS + " or " +
+			base.SHRT_ADMINHTPASSWD + " must be set")
+	}
+	if tokens != "" {
+		f, err := os.Open(tokens)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		if creds.Tokens, err = shrt.ReadTokens(f); err != nil {
+			return nil, fmt.Errorf("%s: %s", tokens, err)
+		}
+	}
+	if htpasswd != "" {
+		f, err := os.Open(htpasswd)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		if creds.Users, err = shrt.ReadHtpasswd(f); err != nil {
+			return nil, fmt.Errorf("%s: %s", htpasswd, err)
+		}
+	}
+	return creds, nil
+}
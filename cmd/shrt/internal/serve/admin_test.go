@@ -0,0 +1,48 @@
+// See LICENSE file for copyright and license details
+
+package serve
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"djmo.ch/go-shrt"
+	"djmo.ch/go-shrt/cmd/shrt/internal/base"
+)
+
+// setAdminEnv sets the environment for adminHandlers, with the admin
+// API and web UI disabled.
+func setAdminEnv(t *testing.T) {
+	t.Helper()
+	tokens := filepath.Join(t.TempDir(), "tokens")
+	if err := os.WriteFile(tokens, []byte("secret\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv(base.SHRT_ADMINADDR, "")
+	t.Setenv(base.SHRT_ADMINPREFIX, "")
+	t.Setenv(base.SHRT_UIPREFIX, "")
+	t.Setenv(base.SHRT_ADMINTOKENS, tokens)
+	t.Setenv(base.SHRT_ADMINHTPASSWD, "")
+	t.Setenv(base.SHRT_KEYALPHABET, shrt.DefaultKeyAlphabet)
+	t.Setenv(base.SHRT_KEYLENGTH, "6")
+	t.Setenv(base.SHRT_KEYSTRATEGY, "random")
+	t.Setenv(base.SHRT_KEYBLOCKLIST, "")
+}
+
+func TestAdminRootPrefix(t *testing.T) {
+	h := &shrt.ShrtHandler{Config: shrt.Config{DbPath: "shrt.db"}}
+	setAdminEnv(t)
+	t.Setenv(base.SHRT_ADMINPREFIX, "/")
+	if _, _, err := adminHandlers(h); err == nil {
+		t.Error("root prefix on the main listener: no error")
+	}
+	t.Setenv(base.SHRT_ADMINADDR, "http://127.0.0.1:8081")
+	admin, _, err := adminHandlers(h)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if admin == nil || admin.Prefix != "" {
+		t.Errorf("root prefix on the admin listener: got %+v", admin)
+	}
+}
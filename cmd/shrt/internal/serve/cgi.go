@@ -0,0 +1,62 @@
+// See LICENSE file for copyright and license details
+
+package serve
+
+import (
+	"io/fs"
+	"log"
+	"net/http/cgi"
+	"os"
+	"path/filepath"
+
+	"djmo.ch/go-shrt"
+	"djmo.ch/go-shrt/cmd/shrt/internal/base"
+)
+
+// serveCGI serves the CGI request described by the environment. The
+// handler is lazy, so reading the database is the only work done
+// that the request does not need.
+func serveCGI(cfg shrt.Config) {
+	switch {
+	case *serveW > 0:
+		log.Fatal("-w cannot be used in cgi mode")
+	case os.Getenv(base.SHRT_ADMINADDR) != "":
+		log.Fatalf("%s cannot be used in cgi mode", base.SHRT_ADMINADDR)
+	case os.Getenv(base.SHRT_CHROOT) != "" || os.Getenv(base.SHRT_USER) != "":
+		log.Fatalf("%s and %s cannot be used in cgi mode", base.SHRT_CHROOT, base.SHRT_USER)
+	}
+	h := &shrt.ShrtHandler{
+		Config:   cfg,
+		ShrtFile: shrt.NewShrtFile(),
+		FS:       os.DirFS("/").(fs.StatFS),
+		Lazy:     true,
+	}
+	if err := h.Reload(); err != nil {
+		logDbError(cfg.DbPath, err)
+		os.Exit(1)
+	}
+	root := &router{fallback: h}
+	acc := access{read: []string{"/" + cfg.DbPath}}
+	admin, ui, err := adminHandlers(h)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if ui != nil {
+		// Each request is served by a new process, so a key chosen
+		// at random would reject every token issued by an earlier one
+		if len(ui.Secret) == 0 {
+			log.Fatalf("%s must be set to use the web UI in cgi mode", base.SHRT_UISECRET)
+		}
+		root.mount(ui.Prefix, ui)
+	}
+	if admin != nil {
+		root.mount(admin.Prefix, admin)
+	}
+	if admin != nil || ui != nil {
+		acc.write = append(acc.write, filepath.Dir("/"+cfg.DbPath))
+	}
+	restrict(acc)
+	if err := cgi.Serve(root); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,91 @@
+// See LICENSE file for copyright and license details
+
+package serve
+
+import (
+	"crypto/sha1"
+	"encoding/base64"
+	"net/http"
+	"net/http/cgi"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+
+	"djmo.ch/go-shrt"
+	"djmo.ch/go-shrt/cmd/shrt/internal/base"
+)
+
+// TestCGIHelper serves a single CGI request when run by TestCGIUI.
+func TestCGIHelper(t *testing.T) {
+	db := os.Getenv("SHRT_TEST_CGI")
+	if db == "" {
+		t.Skip("not run by TestCGIUI")
+	}
+	serveCGI(shrt.Config{SrvName: "example.org", ScmType: "git", DbPath: strings.TrimPrefix(db, "/")})
+}
+
+func TestCGIUI(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, data string) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(data), 0600); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	sum := sha1.Sum([]byte("secret"))
+	db := write("shrt.db", "foo=shrtlnk:https://example.com/foo\n")
+	env := []string{
+		"SHRT_TEST_CGI=" + filepath.ToSlash(db),
+		base.SHRT_UIPREFIX + "=/ui",
+		base.SHRT_ADMINHTPASSWD + "=" + write("htpasswd", "alice:{SHA}"+base64.StdEncoding.EncodeToString(sum[:])+"\n"),
+		base.SHRT_KEYALPHABET + "=" + shrt.DefaultKeyAlphabet,
+		base.SHRT_KEYLENGTH + "=6",
+		base.SHRT_KEYSTRATEGY + "=random",
+		base.SHRT_LOCKDOWN + "=off",
+	}
+	do := func(h http.Handler, req *http.Request) *httptest.ResponseRecorder {
+		req.SetBasicAuth("alice", "secret")
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		return w
+	}
+	handler := func(env ...string) http.Handler {
+		return &cgi.Handler{
+			Path: os.Args[0],
+			Args: []string{"-test.run=^TestCGIHelper$"},
+			Env:  env,
+		}
+	}
+
+	// Without a configured key, the web UI cannot work
+	w := do(handler(env...), httptest.NewRequest(http.MethodGet, "/ui/", nil))
+	if w.Code == http.StatusOK {
+		t.Errorf("%s unset: got status %d", base.SHRT_UISECRET, w.Code)
+	}
+
+	h := handler(append(env, base.SHRT_UISECRET+"="+write("uisecret", "0123456789abcdef\n"))...)
+	w = do(h, httptest.NewRequest(http.MethodGet, "/ui/", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("GET: got status %d:\n%s", w.Code, w.Body)
+	}
+	m := regexp.MustCompile(`name="csrf" value="([^"]+)"`).FindStringSubmatch(w.Body.String())
+	if m == nil {
+		t.Fatalf("GET: no CSRF token in page:\n%s", w.Body)
+	}
+
+	form := url.Values{"csrf": {m[1]}, "key": {"bar"}, "type": {"shrtlnk"}, "url": {"https://example.com/bar"}}
+	req := httptest.NewRequest(http.MethodPost, "/ui/", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w = do(h, req)
+	if w.Code != http.StatusSeeOther {
+		t.Fatalf("POST: got status %d:\n%s", w.Code, w.Body)
+	}
+	if b, _ := os.ReadFile(db); !strings.Contains(string(b), "bar=shrtlnk:https://example.com/bar") {
+		t.Errorf("entry not added:\n%s", b)
+	}
+}
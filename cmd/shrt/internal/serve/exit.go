@@ -0,0 +1,164 @@
+// See LICENSE file for copyright and license details
+
+package serve
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+var (
+	exitMu   sync.Mutex
+	exitFns  []func()
+	stopFns  []func(context.Context) error
+	exitDone bool
+
+	// inflight is the number of requests in progress.
+	inflight int64
+)
+
+// atExit registers fn to be called when the server exits, whether
+// because of an error or because it was asked to stop.
+func atExit(fn func()) {
+	exitMu.Lock()
+	defer exitMu.Unlock()
+	exitFns = append(exitFns, fn)
+}
+
+// atStop registers fn to be called when the server is asked to stop.
+// It should stop accepting requests, and may wait for those in
+// progress to complete until ctx is done.
+func atStop(fn func(ctx context.Context) error) {
+	exitMu.Lock()
+	defer exitMu.Unlock()
+	stopFns = append(stopFns, fn)
+}
+
+// cleanup calls the functions registered with atExit, most recent
+// first. Only the first call to cleanup or shutdown has any effect.
+func cleanup() {
+	exitMu.Lock()
+	defer exitMu.Unlock()
+	if exitDone {
+		return
+	}
+	exitDone = true
+	runExitFns()
+}
+
+// runExitFns calls the functions registered with atExit. The caller
+// must hold exitMu.
+func runExitFns() {
+	for i := len(exitFns) - 1; i >= 0; i-- {
+		exitFns[i]()
+	}
+}
+
+// shutdown stops the server gracefully: it calls the functions
+// registered with atStop, waits up to timeout for the requests in
+// progress to complete, and then cleans up. It reports whether every
+// request completed.
+func shutdown(timeout time.Duration) bool {
+	exitMu.Lock()
+	defer exitMu.Unlock()
+	if exitDone {
+		return true
+	}
+	exitDone = true
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	var wg sync.WaitGroup
+	for _, fn := range stopFns {
+		wg.Add(1)
+		go func(fn func(context.Context) error) {
+			defer wg.Done()
+			fn(ctx)
+		}(fn)
+	}
+	wg.Wait()
+	drained := drain(ctx)
+	runExitFns()
+	return drained
+}
+
+// drain waits until no requests are in progress or ctx is done, and
+// reports whether no requests are in progress.
+func drain(ctx context.Context) bool {
+	tick := time.NewTicker(10 * time.Millisecond)
+	defer tick.Stop()
+	for atomic.LoadInt64(&inflight) > 0 {
+		select {
+		case <-ctx.Done():
+			return false
+		case <-tick.C:
+		}
+	}
+	return true
+}
+
+// track counts the requests in progress in h, so that they can be
+// waited for by shutdown.
+func track(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		atomic.AddInt64(&inflight, 1)
+		defer atomic.AddInt64(&inflight, -1)
+		h.ServeHTTP(w, req)
+	})
+}
+
+// exiting reports whether the server has begun to exit. It waits
+// for any shutdown or cleanup in progress to finish.
+func exiting() bool {
+	exitMu.Lock()
+	defer exitMu.Unlock()
+	return exitDone
+}
+
+// fatal is like log.Fatal, but cleans up before exiting. If the
+// server is already exiting, for example because closing a listener
+// during shutdown made it fail, fatal blocks instead.
+func fatal(v ...interface{}) {
+	if exiting() {
+		select {}
+	}
+	log.Print(v...)
+	cleanup()
+	os.Exit(1)
+}
+
+// fatalf is like fatal, with the arguments of log.Fatalf.
+func fatalf(format string, v ...interface{}) {
+	fatal(fmt.Sprintf(format, v...))
+}
+
+// exitOnSignal shuts the server down gracefully when the process is
+// interrupted or terminated, allowing up to timeout for requests in
+// progress to complete, and exits. The exit status is zero if every
+// request completed. A second signal makes the server exit at once.
+func exitOnSignal(timeout time.Duration) {
+	sig := make(chan os.Signal, 2)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	s := <-sig
+	log.Printf("received %v; shutting down", s)
+	notify("STOPPING=1")
+	go func() {
+		s := <-sig
+		log.Printf("received %v; exiting immediately", s)
+		os.Exit(1)
+	}()
+	if !shutdown(timeout) {
+		log.Printf("drain timeout of %v expired with %d requests in progress", timeout, atomic.LoadInt64(&inflight))
+		os.Exit(1)
+	}
+	log.Println("shutdown complete")
+	os.Exit(0)
+}
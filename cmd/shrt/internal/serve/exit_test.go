@@ -0,0 +1,90 @@
+// See LICENSE file for copyright and license details
+
+package serve
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+)
+
+// resetExit forgets the functions registered with atExit and atStop
+// and allows shutdown to run again.
+func resetExit(t *testing.T) {
+	t.Helper()
+	exitMu.Lock()
+	defer exitMu.Unlock()
+	exitFns, stopFns, exitDone = nil, nil, false
+}
+
+func TestShutdown(t *testing.T) {
+	for _, tt := range []struct {
+		name    string
+		timeout time.Duration
+		drained bool
+	}{
+		{"drained", 5 * time.Second, true},
+		{"timeout", 50 * time.Millisecond, false},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			resetExit(t)
+			l, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			started, release := make(chan bool), make(chan bool)
+			h := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+				started <- true
+				<-release
+				io.WriteString(w, "done")
+			})
+			served := make(chan error, 1)
+			go func() { served <- serve(l, &url.URL{Scheme: "http"}, h, nil, &limits{maxHeaderBytes: 1 << 10}, nil) }()
+			cleaned := false
+			atExit(func() { cleaned = true })
+
+			body := make(chan string, 1)
+			go func() {
+				rsp, err := http.Get("http://" + l.Addr().String())
+				if err != nil {
+					body <- err.Error()
+					return
+				}
+				b, _ := io.ReadAll(rsp.Body)
+				rsp.Body.Close()
+				body <- string(b)
+			}()
+			<-started
+
+			done := make(chan bool, 1)
+			go func() { done <- shutdown(tt.timeout) }()
+			if tt.drained {
+				select {
+				case <-done:
+					t.Fatal("shutdown returned with a request in progress")
+				case <-time.After(50 * time.Millisecond):
+				}
+				release <- true
+				if b := <-body; b != "done" {
+					t.Errorf("got response %q", b)
+				}
+			}
+			if drained := <-done; drained != tt.drained {
+				t.Errorf("shutdown returned %v, want %v", drained, tt.drained)
+			}
+			if !cleaned {
+				t.Error("exit functions not called")
+			}
+			if err := <-served; err != http.ErrServerClosed {
+				t.Errorf("serve returned %v", err)
+			}
+			if !tt.drained {
+				close(release)
+			}
+		})
+	}
+	resetExit(t)
+}
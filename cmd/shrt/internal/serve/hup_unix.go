@@ -3,28 +3,19 @@
 package serve
 
 import (
-	"fmt"
+	"log"
 	"os"
 	"os/signal"
 	"syscall"
-
-	"djmo.ch/go-shrt"
 )
 
 func init() {
-	hangup = func(h *shrt.ShrtHandler) {
+	hangup = func(reload func()) {
 		hup := make(chan os.Signal, 1)
 		signal.Notify(hup, syscall.SIGHUP)
-		for {
-			<-hup
-			f, err := h.FS.Open(h.Config.DbPath)
-			if err != nil {
-				panic(fmt.Sprint("could not open", h.Config.DbPath))
-			}
-			err = h.ShrtFile.ReadShrtFile(f)
-			if err != nil {
-				panic(fmt.Sprint("db error:", err))
-			}
+		for range hup {
+			log.Println("SIGHUP received; reloading")
+			reload()
 		}
 	}
 }
@@ -0,0 +1,126 @@
+// See LICENSE file for copyright and license details
+
+package serve
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// listenFDsStart is the first file descriptor passed by systemd.
+const listenFDsStart = 3
+
+var (
+	systemdOnce  sync.Once
+	systemdFiles []*os.File // nil once claimed
+	systemdNames []string
+	systemdErr   error
+)
+
+// protocol returns the protocol served on u, which is its scheme
+// without any "+systemd" or "+fd" suffix.
+func protocol(u *url.URL) string {
+	p, _, _ := strings.Cut(u.Scheme, "+")
+	return p
+}
+
+// listenInherited returns a listener for a socket inherited from the
+// parent process, as described by u. If the scheme of u ends in
+// "+systemd", the socket is one passed by systemd socket activation,
+// selected by the name given as the host of u, as set with
+// FileDescriptorName=, or the only one passed if no name is given.
+// If the scheme ends in "+fd", the host of u is the number of an
+// open file descriptor, such as 0 for a program started by inetd in
+// wait mode.
+func listenInherited(u *url.URL) (net.Listener, error) {
+	var f *os.File
+	_, how, _ := strings.Cut(u.Scheme, "+")
+	switch how {
+	case "fd":
+		fd, err := strconv.Atoi(u.Host)
+		if err != nil || fd < 0 {
+			return nil, fmt.Errorf("invalid file descriptor: %q", u.Host)
+		}
+		f = os.NewFile(uintptr(fd), "fd "+u.Host)
+	case "systemd":
+		var err error
+		if f, err = systemdFile(u.Host); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unknown scheme: %s", u.Scheme)
+	}
+	defer f.Close() // the listener holds a duplicate
+	l, err := net.FileListener(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", f.Name(), err)
+	}
+	return l, nil
+}
+
+// systemdFile claims the socket passed by systemd with the given
+// name, or the only socket if name is empty. Each socket may be
+// claimed once.
+func systemdFile(name string) (*os.File, error) {
+	systemdOnce.Do(readListenFDs)
+	if systemdErr != nil {
+		return nil, systemdErr
+	}
+	i := -1
+	if name == "" {
+		if len(systemdFiles) != 1 {
+			return nil, fmt.Errorf("systemd passed %d sockets; name one with FileDescriptorName=", len(systemdFiles))
+		}
+		i = 0
+	} else {
+		for j, n := range systemdNames {
+			if n == name && systemdFiles[j] != nil {
+				i = j
+				break
+			}
+		}
+	}
+	if i < 0 || systemdFiles[i] == nil {
+		return nil, fmt.Errorf("no systemd socket named %q", name)
+	}
+	f := systemdFiles[i]
+	systemdFiles[i] = nil
+	return f, nil
+}
+
+// readListenFDs reads the sockets passed by systemd, as described in
+// sd_listen_fds(3), and removes the variables describing them from
+// the environment so that they are not inherited by child processes.
+func readListenFDs() {
+	pid, fds, names := os.Getenv("LISTEN_PID"), os.Getenv("LISTEN_FDS"), os.Getenv("LISTEN_FDNAMES")
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+	if pid == "" || fds == "" {
+		systemdErr = fmt.Errorf("no sockets passed by systemd")
+		return
+	}
+	if pid != strconv.Itoa(os.Getpid()) {
+		systemdErr = fmt.Errorf("sockets passed by systemd are for process %s", pid)
+		return
+	}
+	n, err := strconv.Atoi(fds)
+	if err != nil || n < 1 {
+		systemdErr = fmt.Errorf("invalid LISTEN_FDS: %q", fds)
+		return
+	}
+	if names != "" {
+		systemdNames = strings.Split(names, ":")
+	}
+	for i := 0; i < n; i++ {
+		systemdFiles = append(systemdFiles, os.NewFile(uintptr(listenFDsStart+i), "systemd socket "+strconv.Itoa(i)))
+		if i >= len(systemdNames) {
+			systemdNames = append(systemdNames, "unknown")
+		}
+	}
+}
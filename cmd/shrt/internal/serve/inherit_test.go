@@ -0,0 +1,117 @@
+// See LICENSE file for copyright and license details
+
+//go:build unix
+
+package serve
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestListenInheritedFD(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	f, err := l.(*net.TCPListener).File()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// f is closed by listenInherited
+	u := &url.URL{Scheme: "http+fd", Host: fmt.Sprint(f.Fd())}
+	il, err := listenInherited(u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer il.Close()
+	if il.Addr().String() != l.Addr().String() {
+		t.Errorf("got listener on %s, want %s", il.Addr(), l.Addr())
+	}
+	if protocol(u) != "http" {
+		t.Errorf("got protocol %q", protocol(u))
+	}
+	if _, err := listenInherited(&url.URL{Scheme: "http+fd", Host: "x"}); err == nil {
+		t.Error("accepted an invalid file descriptor")
+	}
+}
+
+// TestListenInheritedSystemd runs TestSystemdHelper in a child
+// process, passing it two sockets as systemd would.
+func TestListenInheritedSystemd(t *testing.T) {
+	if os.Getenv("SHRT_TEST_SYSTEMD") != "" {
+		return
+	}
+	var files []*os.File
+	for i := 0; i < 2; i++ {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer l.Close()
+		f, err := l.(*net.TCPListener).File()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		files = append(files, f)
+	}
+	// The shell sets LISTEN_PID to its own process ID, which the
+	// test binary keeps when the shell execs it.
+	cmd := exec.Command("/bin/sh", "-c", `LISTEN_PID=$$ exec "$0" "$@"`,
+		os.Args[0], "-test.run=^TestSystemdHelper$")
+	cmd.Env = append(os.Environ(), "SHRT_TEST_SYSTEMD=1", "LISTEN_FDS=2", "LISTEN_FDNAMES=web:admin")
+	cmd.ExtraFiles = files
+	out, err := cmd.CombinedOutput()
+	if err != nil || !strings.Contains(string(out), "PASS") {
+		t.Errorf("%v:\n%s", err, out)
+	}
+}
+
+func TestSystemdHelper(t *testing.T) {
+	if os.Getenv("SHRT_TEST_SYSTEMD") == "" {
+		t.Skip("run by TestListenInheritedSystemd")
+	}
+	if _, err := listenInherited(&url.URL{Scheme: "http+systemd"}); err == nil {
+		t.Error("chose one of two sockets without a name")
+	}
+	for _, name := range []string{"admin", "web"} {
+		l, err := listenInherited(&url.URL{Scheme: "https+systemd", Host: name})
+		if err != nil {
+			t.Fatal(err)
+		}
+		l.Close()
+	}
+	if _, err := listenInherited(&url.URL{Scheme: "http+systemd", Host: "web"}); err == nil {
+		t.Error("claimed a socket twice")
+	}
+	if os.Getenv("LISTEN_FDS") != "" {
+		t.Error("LISTEN_FDS left in the environment")
+	}
+}
+
+func TestNotify(t *testing.T) {
+	addr := filepath.Join(t.TempDir(), "notify")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: addr, Net: "unixgram"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	t.Setenv("NOTIFY_SOCKET", addr)
+	notify("READY=1", "STATUS=ok")
+	buf := make([]byte, 64)
+	n, err := conn.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := string(buf[:n]); got != "READY=1\nSTATUS=ok" {
+		t.Errorf("got %q", got)
+	}
+}
@@ -0,0 +1,120 @@
+// See LICENSE file for copyright and license details
+
+package serve
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"os/user"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"djmo.ch/go-shrt/cmd/shrt/internal/base"
+	"djmo.ch/go-shrt/cmd/shrt/internal/env"
+)
+
+// changeRoot and setIDs change the root directory and the user and
+// group of the process, on systems that support it.
+var (
+	changeRoot func(dir string) error
+	setIDs     func(uid, gid int) error
+)
+
+// A jail is the root directory and user to which the server confines
+// itself, as set in SHRT_CHROOT and SHRT_USER.
+type jail struct {
+	dir       string // empty to keep the root directory
+	uid, gid  int    // -1 to keep the user and group
+	reloadEnv bool   // whether SHRTENV can be reread from inside
+}
+
+// jailFromEnv returns the jail set in the environment. Users and
+// groups are looked up now, while the user database is reachable.
+func jailFromEnv() (*jail, error) {
+	j := &jail{dir: os.Getenv(base.SHRT_CHROOT), uid: -1, gid: -1, reloadEnv: true}
+	if j.dir != "" {
+		if changeRoot == nil {
+			return nil, fmt.Errorf("%s is not supported on %s", base.SHRT_CHROOT, runtime.GOOS)
+		}
+		if !filepath.IsAbs(j.dir) {
+			return nil, fmt.Errorf("%s must be an absolute path", base.SHRT_CHROOT)
+		}
+		j.dir = filepath.Clean(j.dir)
+	}
+	owner := os.Getenv(base.SHRT_USER)
+	if owner == "" {
+		return j, nil
+	}
+	if setIDs == nil {
+		return nil, fmt.Errorf("%s is not supported on %s", base.SHRT_USER, runtime.GOOS)
+	}
+	uid, gid, err := ownerIDs(base.SHRT_USER, owner)
+	if err != nil {
+		return nil, err
+	}
+	if uid == -1 {
+		return nil, fmt.Errorf("%s must name a user", base.SHRT_USER)
+	}
+	if gid == -1 {
+		u, err := user.LookupId(strconv.Itoa(uid))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", base.SHRT_USER, err)
+		}
+		if gid, err = strconv.Atoi(u.Gid); err != nil {
+			return nil, fmt.Errorf("%s: user %s has no numeric group ID", base.SHRT_USER, owner)
+		}
+	}
+	j.uid, j.gid = uid, gid
+	return j, nil
+}
+
+// chroot changes the root directory to that of j, if any, after
+// preparing what is needed later from outside it. SHRTENV is reread
+// from inside on SIGHUP if it is there; otherwise it is not reread.
+func (j *jail) chroot() error {
+	if j.dir == "" {
+		return nil
+	}
+	_ = time.Local.String() // loads the time zone
+	connectNotify()
+	if envFile := os.Getenv(base.SHRTENV); envFile != "" {
+		abs, err := filepath.Abs(envFile)
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(j.dir, abs)
+		switch {
+		case err == nil && rel != ".." && !strings.HasPrefix(rel, "../"):
+			env.SetEnvFile("/" + rel)
+		case fileExists(abs):
+			log.Printf("%s is outside %s; it will not be reread on SIGHUP", envFile, j.dir)
+			j.reloadEnv = false
+		}
+	}
+	if err := changeRoot(j.dir); err != nil {
+		return fmt.Errorf("chroot %s: %s", j.dir, err)
+	}
+	log.Println("changed root to", j.dir)
+	return nil
+}
+
+// drop switches to the user and group of j, if any.
+func (j *jail) drop() error {
+	if j.uid == -1 {
+		return nil
+	}
+	if err := setIDs(j.uid, j.gid); err != nil {
+		return fmt.Errorf("%s: %s", base.SHRT_USER, err)
+	}
+	log.Println("running as", os.Getenv(base.SHRT_USER))
+	return nil
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
@@ -0,0 +1,48 @@
+// See LICENSE file for copyright and license details
+
+//go:build unix
+
+package serve
+
+import (
+	"os/user"
+	"strconv"
+	"testing"
+
+	"djmo.ch/go-shrt/cmd/shrt/internal/base"
+)
+
+func TestJailFromEnv(t *testing.T) {
+	u, err := user.Current()
+	if err != nil {
+		t.Skip(err)
+	}
+	uid, _ := strconv.Atoi(u.Uid)
+	gid, _ := strconv.Atoi(u.Gid)
+
+	tests := []struct {
+		dir, owner string
+		want       jail
+		ok         bool
+	}{
+		{"", "", jail{uid: -1, gid: -1, reloadEnv: true}, true},
+		{"/var/shrt/", "", jail{dir: "/var/shrt", uid: -1, gid: -1, reloadEnv: true}, true},
+		{"var/shrt", "", jail{}, false},
+		{"", u.Uid, jail{uid: uid, gid: gid, reloadEnv: true}, true},
+		{"", u.Uid + ":12345", jail{uid: uid, gid: 12345, reloadEnv: true}, true},
+		{"", ":12345", jail{}, false},
+		{"", "no-such-user-shrt", jail{}, false},
+	}
+	for _, tt := range tests {
+		t.Setenv(base.SHRT_CHROOT, tt.dir)
+		t.Setenv(base.SHRT_USER, tt.owner)
+		j, err := jailFromEnv()
+		if (err == nil) != tt.ok {
+			t.Errorf("dir %q, owner %q: got error %v", tt.dir, tt.owner, err)
+			continue
+		}
+		if err == nil && *j != tt.want {
+			t.Errorf("dir %q, owner %q: got %+v, want %+v", tt.dir, tt.owner, *j, tt.want)
+		}
+	}
+}
@@ -0,0 +1,37 @@
+// See LICENSE file for copyright and license details
+
+//go:build unix
+
+package serve
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+func init() {
+	changeRoot = func(dir string) error {
+		if err := syscall.Chroot(dir); err != nil {
+			return err
+		}
+		return os.Chdir("/")
+	}
+	setIDs = func(uid, gid int) error {
+		if os.Geteuid() == 0 {
+			if err := syscall.Setgroups([]int{gid}); err != nil {
+				return err
+			}
+		}
+		if err := syscall.Setgid(gid); err != nil {
+			return err
+		}
+		if err := syscall.Setuid(uid); err != nil {
+			return err
+		}
+		if uid != 0 && syscall.Setuid(0) == nil {
+			return errors.New("root privileges could be regained")
+		}
+		return nil
+	}
+}
@@ -0,0 +1,148 @@
+// See LICENSE file for copyright and license details
+
+package serve
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"djmo.ch/go-shrt/cmd/shrt/internal/base"
+)
+
+// limits are the timeouts and resource limits applied to each
+// listener. Zero means no limit.
+type limits struct {
+	readTimeout    time.Duration
+	headerTimeout  time.Duration
+	writeTimeout   time.Duration
+	idleTimeout    time.Duration
+	maxHeaderBytes int
+	maxConns       int
+	maxPathLen     int
+}
+
+// limitsFromEnv returns the limits set in the environment.
+func limitsFromEnv() (*limits, error) {
+	lim := new(limits)
+	for _, d := range []struct {
+		key string
+		val *time.Duration
+	}{
+		{base.SHRT_READTIMEOUT, &lim.readTimeout},
+		{base.SHRT_HEADERTIMEOUT, &lim.headerTimeout},
+		{base.SHRT_WRITETIMEOUT, &lim.writeTimeout},
+		{base.SHRT_IDLETIMEOUT, &lim.idleTimeout},
+	} {
+		v, err := time.ParseDuration(os.Getenv(d.key))
+		if err != nil || v < 0 {
+			return nil, fmt.Errorf("invalid %s: %q", d.key, os.Getenv(d.key))
+		}
+		*d.val = v
+	}
+	for _, n := range []struct {
+		key string
+		val *int
+	}{
+		{base.SHRT_MAXHEADERBYTES, &lim.maxHeaderBytes},
+		{base.SHRT_MAXCONNS, &lim.maxConns},
+		{base.SHRT_MAXPATHLEN, &lim.maxPathLen},
+	} {
+		v, err := strconv.Atoi(os.Getenv(n.key))
+		if err != nil || v < 0 {
+			return nil, fmt.Errorf("invalid %s: %q", n.key, os.Getenv(n.key))
+		}
+		*n.val = v
+	}
+	if lim.maxHeaderBytes == 0 {
+		return nil, fmt.Errorf("%s must be positive", base.SHRT_MAXHEADERBYTES)
+	}
+	return lim, nil
+}
+
+// server returns an http.Server for handler with the limits applied.
+func (lim *limits) server(handler http.Handler) *http.Server {
+	return &http.Server{
+		Handler:           handler,
+		ReadTimeout:       lim.readTimeout,
+		ReadHeaderTimeout: lim.headerTimeout,
+		WriteTimeout:      lim.writeTimeout,
+		IdleTimeout:       lim.idleTimeout,
+		MaxHeaderBytes:    lim.maxHeaderBytes,
+	}
+}
+
+// handler returns a handler that refuses requests to h whose paths
+// are too long.
+func (lim *limits) handler(h http.Handler) http.Handler {
+	if lim.maxPathLen == 0 {
+		return h
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if len(req.URL.EscapedPath()) > lim.maxPathLen {
+			http.Error(w, "Request path too long", http.StatusRequestURITooLong)
+			return
+		}
+		h.ServeHTTP(w, req)
+	})
+}
+
+// listener returns a listener that accepts at most maxConns
+// connections from l at once.
+func (lim *limits) listener(l net.Listener) net.Listener {
+	if lim.maxConns == 0 {
+		return l
+	}
+	return &limitListener{
+		Listener: l,
+		sem:      make(chan struct{}, lim.maxConns),
+		done:     make(chan struct{}),
+	}
+}
+
+// A limitListener limits the number of connections open at once.
+// Accept waits until one of the connections it returned is closed.
+type limitListener struct {
+	net.Listener
+	sem       chan struct{}
+	done      chan struct{} // closed by Close
+	closeOnce sync.Once
+}
+
+func (l *limitListener) Accept() (net.Conn, error) {
+	select {
+	case l.sem <- struct{}{}:
+	case <-l.done:
+		return nil, net.ErrClosed
+	}
+	c, err := l.Listener.Accept()
+	if err != nil {
+		<-l.sem
+		return nil, err
+	}
+	return &limitConn{Conn: c, release: func() { <-l.sem }}, nil
+}
+
+func (l *limitListener) Close() error {
+	err := l.Listener.Close()
+	l.closeOnce.Do(func() { close(l.done) })
+	return err
+}
+
+// A limitConn releases its slot in a limitListener when it is first
+// closed.
+type limitConn struct {
+	net.Conn
+	once    sync.Once
+	release func()
+}
+
+func (c *limitConn) Close() error {
+	err := c.Conn.Close()
+	c.once.Do(c.release)
+	return err
+}
@@ -0,0 +1,123 @@
+// See LICENSE file for copyright and license details
+
+package serve
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"djmo.ch/go-shrt/cmd/shrt/internal/base"
+)
+
+func setLimitsEnv(t *testing.T) {
+	t.Helper()
+	t.Setenv(base.SHRT_READTIMEOUT, "15s")
+	t.Setenv(base.SHRT_HEADERTIMEOUT, "5s")
+	t.Setenv(base.SHRT_WRITETIMEOUT, "30s")
+	t.Setenv(base.SHRT_IDLETIMEOUT, "0")
+	t.Setenv(base.SHRT_MAXHEADERBYTES, "32768")
+	t.Setenv(base.SHRT_MAXCONNS, "1024")
+	t.Setenv(base.SHRT_MAXPATHLEN, "0")
+}
+
+func TestLimitsFromEnv(t *testing.T) {
+	setLimitsEnv(t)
+	lim, err := limitsFromEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := limits{
+		readTimeout:    15 * time.Second,
+		headerTimeout:  5 * time.Second,
+		writeTimeout:   30 * time.Second,
+		maxHeaderBytes: 32768,
+		maxConns:       1024,
+	}
+	if *lim != want {
+		t.Errorf("got %+v, want %+v", *lim, want)
+	}
+
+	for key, val := range map[string]string{
+		base.SHRT_READTIMEOUT:    "15",
+		base.SHRT_IDLETIMEOUT:    "-1s",
+		base.SHRT_MAXCONNS:       "many",
+		base.SHRT_MAXPATHLEN:     "-1",
+		base.SHRT_MAXHEADERBYTES: "0",
+	} {
+		t.Run(key, func(t *testing.T) {
+			setLimitsEnv(t)
+			t.Setenv(key, val)
+			if _, err := limitsFromEnv(); err == nil || !strings.Contains(err.Error(), key) {
+				t.Errorf("%s=%q: got error %v", key, val, err)
+			}
+		})
+	}
+}
+
+func TestMaxPathLen(t *testing.T) {
+	lim := &limits{maxPathLen: 8}
+	h := lim.handler(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {}))
+	for path, code := range map[string]int{
+		"/short":    http.StatusOK,
+		"/12345678": http.StatusRequestURITooLong,
+		"/a%20b%20": http.StatusRequestURITooLong,
+	} {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		if w.Code != code {
+			t.Errorf("%s: got status %d, want %d", path, w.Code, code)
+		}
+	}
+}
+
+func TestMaxConns(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ll := (&limits{maxConns: 1}).listener(l)
+	defer ll.Close()
+
+	accepted := make(chan net.Conn, 2)
+	go func() {
+		for {
+			c, err := ll.Accept()
+			if err != nil {
+				close(accepted)
+				return
+			}
+			accepted <- c
+		}
+	}()
+	for i := 0; i < 2; i++ {
+		c, err := net.Dial("tcp", l.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer c.Close()
+	}
+
+	first := <-accepted
+	select {
+	case <-accepted:
+		t.Fatal("second connection accepted while first is open")
+	case <-time.After(50 * time.Millisecond):
+	}
+	first.Close()
+	first.Close() // releases its slot only once
+	select {
+	case c := <-accepted:
+		c.Close()
+	case <-time.After(5 * time.Second):
+		t.Fatal("second connection not accepted after first closed")
+	}
+
+	ll.Close()
+	if _, ok := <-accepted; ok {
+		t.Error("Accept succeeded after Close")
+	}
+}
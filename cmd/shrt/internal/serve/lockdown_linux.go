@@ -0,0 +1,155 @@
+// See LICENSE file for copyright and license details
+
+package serve
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"path/filepath"
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// errUnavailable is wrapped by the errors of sandboxing mechanisms
+// that the kernel does not support.
+var errUnavailable = errors.New("unavailable")
+
+// errCgo is returned by landlock in programs that use cgo.
+var errCgo = errors.New("Landlock cannot be used by a build with cgo; rebuild shrt with CGO_ENABLED=0 or set SHRT_LOCKDOWN=off")
+
+func init() {
+	lockdownCheck = checkCgo
+	lockdown = func(acc access) {
+		for _, restrict := range []func(access) error{landlock, seccomp} {
+			err := restrict(acc)
+			if errors.Is(err, errUnavailable) {
+				log.Println("lockdown:", err)
+			} else if err != nil {
+				panic(fmt.Sprint("lockdown: ", err))
+			}
+		}
+	}
+}
+
+const (
+	// landlockFileAccess are the access rights that apply to files,
+	// rather than to the directories beneath which they are found.
+	landlockFileAccess = unix.LANDLOCK_ACCESS_FS_EXECUTE |
+		unix.LANDLOCK_ACCESS_FS_WRITE_FILE |
+		unix.LANDLOCK_ACCESS_FS_READ_FILE |
+		unix.LANDLOCK_ACCESS_FS_TRUNCATE
+
+	// landlockABI1 are the access rights known to version 1 of the
+	// Landlock ABI. Versions 2 and 3 add REFER and TRUNCATE.
+	landlockABI1 = unix.LANDLOCK_ACCESS_FS_MAKE_SYM<<1 - 1
+)
+
+// landlock restricts the files the process can access to those in acc,
+// using Landlock, available since Linux 5.13.
+//
+// Rules apply to whole directories, so that files which are replaced
+// rather than modified, such as the database when it is edited and
+// certificates when they are renewed, can still be read.
+func landlock(acc access) error {
+	if err := checkCgo(); err != nil {
+		return err
+	}
+	abi, _, errno := unix.Syscall(unix.SYS_LANDLOCK_CREATE_RULESET, 0, 0, unix.LANDLOCK_CREATE_RULESET_VERSION)
+	if errno != 0 {
+		return fmt.Errorf("Landlock %w: %v", errUnavailable, errno)
+	}
+	handled := uint64(landlockABI1)
+	if abi >= 2 {
+		handled |= unix.LANDLOCK_ACCESS_FS_REFER
+	}
+	if abi >= 3 {
+		handled |= unix.LANDLOCK_ACCESS_FS_TRUNCATE
+	}
+	attr := unix.LandlockRulesetAttr{Access_fs: handled}
+	fd, _, errno := unix.Syscall(unix.SYS_LANDLOCK_CREATE_RULESET, uintptr(unsafe.Pointer(&attr)), unsafe.Sizeof(attr), 0)
+	if errno != 0 {
+		return fmt.Errorf("landlock_create_ruleset: %v", errno)
+	}
+	ruleset := int(fd)
+	defer unix.Close(ruleset)
+
+	const (
+		read  = unix.LANDLOCK_ACCESS_FS_READ_FILE
+		write = unix.LANDLOCK_ACCESS_FS_WRITE_FILE |
+			unix.LANDLOCK_ACCESS_FS_TRUNCATE |
+			unix.LANDLOCK_ACCESS_FS_MAKE_REG |
+			unix.LANDLOCK_ACCESS_FS_REMOVE_FILE
+		remove = unix.LANDLOCK_ACCESS_FS_REMOVE_FILE
+	)
+	rules := make(map[string]uint64)
+	for _, path := range acc.read {
+		rules[filepath.Dir(path)] |= read
+		if target, err := filepath.EvalSymlinks(path); err == nil {
+			rules[filepath.Dir(target)] |= read
+		}
+	}
+	for _, dir := range acc.write {
+		rules[dir] |= read | unix.LANDLOCK_ACCESS_FS_READ_DIR | write
+	}
+	for _, path := range acc.sockets {
+		rules[filepath.Dir(path)] |= remove
+	}
+	for _, path := range acc.remove {
+		rules[path] |= read
+		rules[filepath.Dir(path)] |= remove
+	}
+	for path, access := range rules {
+		if err := landlockAllow(ruleset, path, access&handled); err != nil {
+			return err
+		}
+	}
+
+	_, _, errno = syscall.AllThreadsSyscall(unix.SYS_PRCTL, unix.PR_SET_NO_NEW_PRIVS, 1, 0)
+	if errno != 0 {
+		return fmt.Errorf("prctl: %v", errno)
+	}
+	_, _, errno = syscall.AllThreadsSyscall(unix.SYS_LANDLOCK_RESTRICT_SELF, uintptr(ruleset), 0, 0)
+	if errno != 0 {
+		return fmt.Errorf("landlock_restrict_self: %v", errno)
+	}
+	return nil
+}
+
+// checkCgo returns errCgo if the program uses cgo. Landlock restricts
+// only the thread that enables it, so every thread must do so, which
+// the runtime cannot arrange in programs that use cgo.
+func checkCgo() error {
+	_, _, errno := syscall.AllThreadsSyscall(unix.SYS_PRCTL, unix.PR_GET_NO_NEW_PRIVS, 0, 0)
+	if errno == unix.ENOTSUP {
+		return errCgo
+	}
+	return nil
+}
+
+// landlockAllow adds a rule to ruleset granting access beneath path.
+// Paths that do not exist are ignored.
+func landlockAllow(ruleset int, path string, access uint64) error {
+	fd, err := unix.Open(path, unix.O_PATH|unix.O_CLOEXEC, 0)
+	if errors.Is(err, unix.ENOENT) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("landlock: %s: %v", path, err)
+	}
+	defer unix.Close(fd)
+	var st unix.Stat_t
+	if err := unix.Fstat(fd, &st); err != nil {
+		return fmt.Errorf("landlock: %s: %v", path, err)
+	}
+	if st.Mode&unix.S_IFMT != unix.S_IFDIR {
+		access &= landlockFileAccess
+	}
+	attr := unix.LandlockPathBeneathAttr{Allowed_access: access, Parent_fd: int32(fd)}
+	_, _, errno := unix.Syscall6(unix.SYS_LANDLOCK_ADD_RULE, uintptr(ruleset), unix.LANDLOCK_RULE_PATH_BENEATH, uintptr(unsafe.Pointer(&attr)), 0, 0, 0)
+	if errno != 0 {
+		return fmt.Errorf("landlock: %s: %v", path, errno)
+	}
+	return nil
+}
@@ -0,0 +1,77 @@
+// See LICENSE file for copyright and license details
+
+package serve
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+// TestLockdown runs itself in a child process, which enters the
+// sandbox and checks what it can still do.
+func TestLockdown(t *testing.T) {
+	if dir := os.Getenv("SHRT_TEST_LOCKDOWN"); dir != "" {
+		testLockedDown(t, dir)
+		return
+	}
+	dir := t.TempDir()
+	for _, name := range []string{"db/shrt.db", "other/secret"} {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cmd := exec.Command(os.Args[0], "-test.run=^TestLockdown$", "-test.v")
+	cmd.Env = append(os.Environ(), "SHRT_TEST_LOCKDOWN="+dir)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("%v\n%s", err, out)
+	}
+	t.Logf("%s", out)
+}
+
+func testLockedDown(t *testing.T, dir string) {
+	db := filepath.Join(dir, "db/shrt.db")
+	acc := access{read: []string{db}, write: []string{filepath.Join(dir, "db")}}
+	landlocked, seccomped := true, true
+	if err := landlock(acc); errors.Is(err, errUnavailable) || err == errCgo {
+		t.Log(err)
+		landlocked = false
+	} else if err != nil {
+		t.Fatal(err)
+	}
+	if err := seccomp(acc); errors.Is(err, errUnavailable) {
+		t.Log(err)
+		seccomped = false
+	} else if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := os.ReadFile(db); err != nil {
+		t.Errorf("reading database: %v", err)
+	}
+	if err := os.WriteFile(db+".tmp", nil, 0644); err != nil {
+		t.Errorf("writing next to database: %v", err)
+	} else if err := os.Rename(db+".tmp", db); err != nil {
+		t.Errorf("replacing database: %v", err)
+	}
+	if landlocked {
+		_, err := os.ReadFile(filepath.Join(dir, "other/secret"))
+		if !errors.Is(err, fs.ErrPermission) {
+			t.Errorf("reading outside sandbox: got error %v, want permission denied", err)
+		}
+	}
+	if seccomped {
+		err := os.Symlink(db, filepath.Join(dir, "db/link"))
+		if !errors.Is(err, fs.ErrPermission) {
+			t.Errorf("symlink: got error %v, want operation not permitted", err)
+		}
+	}
+}
@@ -9,13 +9,35 @@ import (
 )
 
 func init() {
-	lockdown = func(dbPath string) {
-		path := "/" + dbPath
-		err := unix.Unveil(path, "r")
-		if err != nil {
-			panic(fmt.Sprint("lockdown: ", err))
+	lockdown = func(acc access) {
+		for _, path := range acc.read {
+			if err := unix.Unveil(path, "r"); err != nil {
+				panic(fmt.Sprint("lockdown: ", err))
+			}
+		}
+		promises := "stdio rpath dns inet flock"
+		for _, path := range acc.write {
+			if err := unix.Unveil(path, "rwc"); err != nil {
+				panic(fmt.Sprint("lockdown: ", err))
+			}
+		}
+		if len(acc.write) > 0 {
+			promises += " wpath cpath fattr"
+		}
+		// Sockets and the pidfile are removed on exit
+		remove := append(acc.sockets[:len(acc.sockets):len(acc.sockets)], acc.remove...)
+		for _, path := range remove {
+			if err := unix.Unveil(path, "rwc"); err != nil {
+				panic(fmt.Sprint("lockdown: ", err))
+			}
+		}
+		if len(acc.sockets) > 0 || acc.unix {
+			promises += " unix"
+		}
+		if len(remove) > 0 && len(acc.write) == 0 {
+			promises += " cpath"
 		}
-		err = unix.Pledge("stdio rpath dns inet flock", "")
+		err := unix.Pledge(promises, "")
 		if err != nil {
 			panic(fmt.Sprint("lockdown: ", err))
 		}
@@ -0,0 +1,64 @@
+// See LICENSE file for copyright and license details
+
+package serve
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strings"
+
+	"djmo.ch/go-shrt"
+)
+
+// notifyConn, if set by connectNotify, is used for every
+// notification instead of a new connection to NOTIFY_SOCKET.
+var notifyConn *net.UnixConn
+
+// connectNotify connects to NOTIFY_SOCKET, if it is set, so that
+// notifications can still be sent once its path is unreachable, as
+// after a chroot.
+func connectNotify() {
+	if addr := os.Getenv("NOTIFY_SOCKET"); addr != "" && notifyConn == nil {
+		conn, err := dialNotify(addr)
+		if err != nil {
+			log.Println("notify:", err)
+			return
+		}
+		notifyConn = conn
+	}
+}
+
+func dialNotify(addr string) (*net.UnixConn, error) {
+	return net.DialUnix("unixgram", nil, &net.UnixAddr{Name: addr, Net: "unixgram"})
+}
+
+// notify sends state to the service manager, as described in
+// sd_notify(3), if it has asked for notifications by setting
+// NOTIFY_SOCKET. Errors are logged and otherwise ignored.
+func notify(state ...string) {
+	conn := notifyConn
+	if conn == nil {
+		addr := os.Getenv("NOTIFY_SOCKET")
+		if addr == "" {
+			return
+		}
+		var err error
+		if conn, err = dialNotify(addr); err != nil {
+			log.Println("notify:", err)
+			return
+		}
+		defer conn.Close()
+	}
+	if _, err := conn.Write([]byte(strings.Join(state, "\n"))); err != nil {
+		log.Println("notify:", err)
+	}
+}
+
+// readyStatus returns the status reported to the service manager
+// while h is serving.
+func readyStatus(h *shrt.ShrtHandler) string {
+	st := h.Status()
+	return fmt.Sprintf("STATUS=serving %d entries (generation %d)", st.Entries, st.Generation)
+}
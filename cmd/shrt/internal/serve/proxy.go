@@ -0,0 +1,349 @@
+// See LICENSE file for copyright and license details
+
+package serve
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"djmo.ch/go-shrt/cmd/shrt/internal/base"
+	"djmo.ch/go-shrt/cmd/shrt/internal/env"
+)
+
+// proxyHeaderTimeout is how long a trusted proxy has to send the
+// PROXY protocol header of a connection.
+const proxyHeaderTimeout = 10 * time.Second
+
+// proxies are the reverse proxies and load balancers whose reports
+// of the addresses of their clients are trusted. A nil *proxies
+// trusts none.
+type proxies struct {
+	trusted  []netip.Prefix
+	unix     bool // whether peers on Unix domain sockets are trusted
+	protocol bool // whether trusted peers use the PROXY protocol
+}
+
+// proxiesFromEnv returns the trusted proxies set in the environment,
+// or nil if there are none.
+func proxiesFromEnv() (*proxies, error) {
+	p := &proxies{protocol: env.Enabled(os.Getenv(base.SHRT_PROXYPROTOCOL))}
+	for _, s := range strings.Fields(strings.ReplaceAll(os.Getenv(base.SHRT_TRUSTEDPROXIES), ",", " ")) {
+		if s == "unix" {
+			p.unix = true
+			continue
+		}
+		prefix, err := parsePrefix(s)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", base.SHRT_TRUSTEDPROXIES, err)
+		}
+		p.trusted = append(p.trusted, prefix)
+	}
+	if len(p.trusted) == 0 && !p.unix {
+		if p.protocol {
+			return nil, fmt.Errorf("%s requires %s", base.SHRT_PROXYPROTOCOL, base.SHRT_TRUSTEDPROXIES)
+		}
+		return nil, nil
+	}
+	return p, nil
+}
+
+// trusts reports whether addr is the address of a trusted proxy.
+func (p *proxies) trusts(addr netip.Addr) bool {
+	addr = addr.Unmap().WithZone("")
+	for _, prefix := range p.trusted {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// trustsPeer reports whether the peer at remote, which connected to
+// local, is a trusted proxy.
+func (p *proxies) trustsPeer(local net.Addr, remote string) bool {
+	if local != nil && local.Network() == "unix" {
+		return p.unix
+	}
+	ap, err := netip.ParseAddrPort(remote)
+	return err == nil && p.trusts(ap.Addr())
+}
+
+// handler returns a handler that serves requests to h after replacing
+// the remote address and scheme of those sent by trusted proxies with
+// those of the original client, as reported in the Forwarded header
+// or else the X-Forwarded-For and X-Forwarded-Proto headers. The
+// scheme of each request is set in req.URL.Scheme.
+func (p *proxies) handler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		req.URL.Scheme = "http"
+		if req.TLS != nil {
+			req.URL.Scheme = "https"
+		}
+		if p != nil {
+			local, _ := req.Context().Value(http.LocalAddrContextKey).(net.Addr)
+			if p.trustsPeer(local, req.RemoteAddr) {
+				p.forwarded(req)
+			}
+		}
+		h.ServeHTTP(w, req)
+	})
+}
+
+// A hop is a client or proxy through which a request was forwarded.
+type hop struct {
+	addr  string // as host:port, or empty if unknown
+	proto string
+}
+
+// forwarded sets the remote address and scheme of req, which was sent
+// by a trusted proxy, to those of the original client.
+func (p *proxies) forwarded(req *http.Request) {
+	var hops []hop
+	if fwd := req.Header.Values("Forwarded"); len(fwd) > 0 {
+		hops = parseForwarded(strings.Join(fwd, ","))
+	} else if xff := req.Header.Values("X-Forwarded-For"); len(xff) > 0 {
+		for _, s := range strings.Split(strings.Join(xff, ","), ",") {
+			hops = append(hops, hop{addr: forwardedAddr(strings.TrimSpace(s))})
+		}
+		if xfp := req.Header.Get("X-Forwarded-Proto"); xfp != "" {
+			proto, _, _ := strings.Cut(xfp, ",")
+			hops[0].proto = strings.TrimSpace(proto)
+		}
+	}
+
+	// The client is the nearest hop that is not a trusted proxy
+	client := -1
+	for i := len(hops) - 1; i >= 0 && hops[i].addr != ""; i-- {
+		client = i
+		ap, _ := netip.ParseAddrPort(hops[i].addr)
+		if !p.trusts(ap.Addr()) {
+			break
+		}
+	}
+	if client < 0 {
+		return
+	}
+	req.RemoteAddr = hops[client].addr
+	proto := hops[client].proto
+	if proto == "" {
+		proto = hops[0].proto
+	}
+	if proto = strings.ToLower(proto); proto == "http" || proto == "https" {
+		req.URL.Scheme = proto
+	}
+}
+
+// parseForwarded returns the hops in the value of a Forwarded header,
+// as described in RFC 7239, from the client to the nearest proxy.
+func parseForwarded(v string) []hop {
+	var hops []hop
+	for _, elem := range splitQuoted(v, ',') {
+		var h hop
+		for _, pair := range splitQuoted(elem, ';') {
+			key, val, _ := strings.Cut(strings.TrimSpace(pair), "=")
+			if uq, err := strconv.Unquote(val); err == nil && strings.HasPrefix(val, `"`) {
+				val = uq
+			}
+			switch strings.ToLower(key) {
+			case "for":
+				h.addr = forwardedAddr(val)
+			case "proto":
+				h.proto = val
+			}
+		}
+		hops = append(hops, h)
+	}
+	return hops
+}
+
+// splitQuoted splits s at each sep outside a quoted string.
+func splitQuoted(s string, sep byte) []string {
+	var (
+		parts  []string
+		quoted bool
+		start  int
+	)
+	for i := 0; i < len(s); i++ {
+		switch {
+		case s[i] == '\\' && quoted:
+			i++
+		case s[i] == '"':
+			quoted = !quoted
+		case s[i] == sep && !quoted:
+			parts = append(parts, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, s[start:])
+}
+
+// forwardedAddr returns the address reported for a hop, which may
+// have a port and be enclosed in brackets, as host:port. The port is
+// 0 if none is given. It returns "" for unknown and obfuscated
+// addresses.
+func forwardedAddr(s string) string {
+	if ap, err := netip.ParseAddrPort(s); err == nil {
+		return netip.AddrPortFrom(ap.Addr().Unmap(), ap.Port()).String()
+	}
+	if addr, err := netip.ParseAddr(strings.TrimSuffix(strings.TrimPrefix(s, "["), "]")); err == nil {
+		return netip.AddrPortFrom(addr.Unmap(), 0).String()
+	}
+	return ""
+}
+
+// listener returns a listener whose connections from trusted proxies
+// begin with a PROXY protocol header, version 1 or 2, giving the
+// addresses of the original connection, if p uses the protocol.
+func (p *proxies) listener(l net.Listener) net.Listener {
+	if p == nil || !p.protocol {
+		return l
+	}
+	return &proxyListener{Listener: l, p: p}
+}
+
+type proxyListener struct {
+	net.Listener
+	p *proxies
+}
+
+func (l *proxyListener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err != nil || !l.p.trustsPeer(c.LocalAddr(), c.RemoteAddr().String()) {
+		return c, err
+	}
+	return &proxyConn{Conn: c, r: bufio.NewReader(c)}, nil
+}
+
+// A proxyConn reads the PROXY protocol header when it is first used,
+// without holding up the listener that accepted it.
+type proxyConn struct {
+	net.Conn
+	r      *bufio.Reader
+	once   sync.Once
+	err    error
+	local  net.Addr
+	remote net.Addr
+}
+
+func (c *proxyConn) init() {
+	c.once.Do(func() {
+		c.Conn.SetReadDeadline(time.Now().Add(proxyHeaderTimeout))
+		c.local, c.remote, c.err = readProxyHeader(c.r)
+		c.Conn.SetReadDeadline(time.Time{})
+		if c.err != nil {
+			c.err = fmt.Errorf("PROXY protocol from %s: %w", c.Conn.RemoteAddr(), c.err)
+		}
+	})
+}
+
+func (c *proxyConn) Read(b []byte) (int, error) {
+	if c.init(); c.err != nil {
+		return 0, c.err
+	}
+	return c.r.Read(b)
+}
+
+func (c *proxyConn) LocalAddr() net.Addr {
+	if c.init(); c.local != nil {
+		return c.local
+	}
+	return c.Conn.LocalAddr()
+}
+
+func (c *proxyConn) RemoteAddr() net.Addr {
+	if c.init(); c.remote != nil {
+		return c.remote
+	}
+	return c.Conn.RemoteAddr()
+}
+
+var proxySig = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// readProxyHeader reads a PROXY protocol header from r and returns the
+// addresses it gives, which are nil if the connection was made by the
+// proxy itself, as for a health check.
+func readProxyHeader(r *bufio.Reader) (local, remote net.Addr, err error) {
+	if sig, err := r.Peek(len(proxySig)); err == nil && bytes.Equal(sig, proxySig) {
+		return readProxyHeaderV2(r)
+	}
+	line, err := r.ReadSlice('\n')
+	if err != nil {
+		if errors.Is(err, bufio.ErrBufferFull) {
+			err = errors.New("header too long")
+		}
+		return nil, nil, err
+	}
+	f := strings.Fields(string(line))
+	if len(f) < 2 || f[0] != "PROXY" || !bytes.HasSuffix(line, []byte("\r\n")) {
+		return nil, nil, errors.New("invalid header")
+	}
+	switch {
+	case f[1] == "UNKNOWN":
+		return nil, nil, nil
+	case (f[1] == "TCP4" || f[1] == "TCP6") && len(f) == 6:
+		src, err1 := netip.ParseAddr(f[2])
+		dst, err2 := netip.ParseAddr(f[3])
+		sport, err3 := strconv.ParseUint(f[4], 10, 16)
+		dport, err4 := strconv.ParseUint(f[5], 10, 16)
+		if err1 != nil || err2 != nil || err3 != nil || err4 != nil || src.Is4() != (f[1] == "TCP4") {
+			return nil, nil, errors.New("invalid header")
+		}
+		return tcpAddr(dst, uint16(dport)), tcpAddr(src, uint16(sport)), nil
+	}
+	return nil, nil, errors.New("invalid header")
+}
+
+// readProxyHeaderV2 reads a binary header of version 2, after its
+// signature has been seen.
+func readProxyHeaderV2(r *bufio.Reader) (local, remote net.Addr, err error) {
+	var hdr [16]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return nil, nil, err
+	}
+	verCmd, family := hdr[12], hdr[13]
+	body := make([]byte, binary.BigEndian.Uint16(hdr[14:]))
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil, nil, err
+	}
+	switch {
+	case verCmd>>4 != 2:
+		return nil, nil, errors.New("unsupported version")
+	case verCmd&0xf == 0: // LOCAL
+		return nil, nil, nil
+	case verCmd&0xf != 1: // PROXY
+		return nil, nil, errors.New("unsupported command")
+	}
+	var n int
+	switch family {
+	case 0x11: // TCP over IPv4
+		n = 4
+	case 0x21: // TCP over IPv6
+		n = 16
+	default:
+		return nil, nil, nil
+	}
+	if len(body) < 2*n+4 {
+		return nil, nil, errors.New("invalid header")
+	}
+	src, _ := netip.AddrFromSlice(body[:n])
+	dst, _ := netip.AddrFromSlice(body[n : 2*n])
+	sport := binary.BigEndian.Uint16(body[2*n:])
+	dport := binary.BigEndian.Uint16(body[2*n+2:])
+	return tcpAddr(dst, dport), tcpAddr(src, sport), nil
+}
+
+func tcpAddr(addr netip.Addr, port uint16) net.Addr {
+	return net.TCPAddrFromAddrPort(netip.AddrPortFrom(addr.Unmap(), port))
+}
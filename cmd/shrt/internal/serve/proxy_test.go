@@ -0,0 +1,165 @@
+// See LICENSE file for copyright and license details
+
+package serve
+
+import (
+	"bufio"
+	"encoding/hex"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strings"
+	"testing"
+
+	"djmo.ch/go-shrt/cmd/shrt/internal/base"
+)
+
+func TestProxiesFromEnv(t *testing.T) {
+	t.Setenv(base.SHRT_TRUSTEDPROXIES, "")
+	t.Setenv(base.SHRT_PROXYPROTOCOL, "off")
+	if p, err := proxiesFromEnv(); p != nil || err != nil {
+		t.Errorf("no proxies: got %v, %v", p, err)
+	}
+	t.Setenv(base.SHRT_PROXYPROTOCOL, "on")
+	if _, err := proxiesFromEnv(); err == nil {
+		t.Errorf("PROXY protocol without proxies: no error")
+	}
+	t.Setenv(base.SHRT_TRUSTEDPROXIES, "10.0.0.0/8,bogus")
+	if _, err := proxiesFromEnv(); err == nil {
+		t.Errorf("invalid subnet: no error")
+	}
+	t.Setenv(base.SHRT_TRUSTEDPROXIES, "10.0.0.0/8, 2001:db8::1 unix")
+	p, err := proxiesFromEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(p.trusted) != 2 || !p.unix || !p.protocol {
+		t.Errorf("got %+v", p)
+	}
+}
+
+func TestForwarded(t *testing.T) {
+	t.Setenv(base.SHRT_TRUSTEDPROXIES, "10.0.0.0/8")
+	t.Setenv(base.SHRT_PROXYPROTOCOL, "off")
+	p, err := proxiesFromEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		remote string
+		header map[string]string
+		addr   string
+		scheme string
+	}{
+		{"192.0.2.1:1234", map[string]string{"X-Forwarded-For": "198.51.100.1"}, "192.0.2.1:1234", "http"},
+		{"10.0.0.1:1234", nil, "10.0.0.1:1234", "http"},
+		{"10.0.0.1:1234", map[string]string{
+			"X-Forwarded-For":   "203.0.113.9, 198.51.100.1, 10.0.0.2",
+			"X-Forwarded-Proto": "https",
+		}, "198.51.100.1:0", "https"},
+		{"10.0.0.1:1234", map[string]string{
+			"Forwarded":       `for=203.0.113.9, for="[2001:db8:cafe::17]:4711";proto=https, for=10.0.0.2`,
+			"X-Forwarded-For": "198.51.100.1",
+		}, "[2001:db8:cafe::17]:4711", "https"},
+		{"10.0.0.1:1234", map[string]string{"Forwarded": `for=unknown;proto=https`}, "10.0.0.1:1234", "http"},
+		{"10.0.0.1:1234", map[string]string{"Forwarded": `for=10.0.0.3;proto=HTTPS`}, "10.0.0.3:0", "https"},
+	}
+	for _, tt := range tests {
+		var addr, scheme string
+		h := p.handler(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			addr, scheme = req.RemoteAddr, req.URL.Scheme
+		}))
+		req := httptest.NewRequest("GET", "/foo", nil)
+		req.RemoteAddr = tt.remote
+		for k, v := range tt.header {
+			req.Header.Set(k, v)
+		}
+		h.ServeHTTP(httptest.NewRecorder(), req)
+		if addr != tt.addr || scheme != tt.scheme {
+			t.Errorf("%s %v: got %s %s, want %s %s", tt.remote, tt.header, scheme, addr, tt.scheme, tt.addr)
+		}
+	}
+}
+
+func TestProxyHeader(t *testing.T) {
+	v2 := func(s string) string {
+		b, err := hex.DecodeString(strings.ReplaceAll(s, " ", ""))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(b)
+	}
+	tests := []struct {
+		header, remote, local string
+		ok                    bool
+	}{
+		{"PROXY TCP4 198.51.100.1 192.0.2.1 56324 443\r\n", "198.51.100.1:56324", "192.0.2.1:443", true},
+		{"PROXY TCP6 2001:db8::1 2001:db8::2 4711 80\r\n", "[2001:db8::1]:4711", "[2001:db8::2]:80", true},
+		{"PROXY UNKNOWN\r\n", "", "", true},
+		{"PROXY TCP4 2001:db8::1 192.0.2.1 1 2\r\n", "", "", false},
+		{"PROXY TCP4 198.51.100.1 192.0.2.1 56324\r\n", "", "", false},
+		{"GET / HTTP/1.1\r\n", "", "", false},
+		{v2("0d0a0d0a000d0a515549540a 21 11 000c c6336401 c0000201 dc04 01bb"), "198.51.100.1:56324", "192.0.2.1:443", true},
+		{v2("0d0a0d0a000d0a515549540a 21 11 000f c6336401 c0000201 dc04 01bb 030000"), "198.51.100.1:56324", "192.0.2.1:443", true},
+		{v2("0d0a0d0a000d0a515549540a 20 00 0000"), "", "", true},
+		{v2("0d0a0d0a000d0a515549540a 21 11 0004 c6336401"), "", "", false},
+		{v2("0d0a0d0a000d0a515549540a 11 11 0000"), "", "", false},
+	}
+	for _, tt := range tests {
+		r := bufio.NewReader(strings.NewReader(tt.header + "rest"))
+		local, remote, err := readProxyHeader(r)
+		if (err == nil) != tt.ok {
+			t.Errorf("%q: got error %v", tt.header, err)
+			continue
+		}
+		if !tt.ok {
+			continue
+		}
+		if got := addrString(remote); got != tt.remote {
+			t.Errorf("%q: got remote %q, want %q", tt.header, got, tt.remote)
+		}
+		if got := addrString(local); got != tt.local {
+			t.Errorf("%q: got local %q, want %q", tt.header, got, tt.local)
+		}
+		if rest, _ := io.ReadAll(r); string(rest) != "rest" {
+			t.Errorf("%q: left %q unread", tt.header, rest)
+		}
+	}
+}
+
+func addrString(a net.Addr) string {
+	if a == nil {
+		return ""
+	}
+	return a.String()
+}
+
+func TestProxyListener(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &proxies{trusted: []netip.Prefix{netip.MustParsePrefix("127.0.0.0/8")}, protocol: true}
+	srv := &http.Server{Handler: p.handler(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		io.WriteString(w, req.RemoteAddr)
+	}))}
+	go srv.Serve(p.listener(l))
+	defer srv.Close()
+
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	io.WriteString(conn, "PROXY TCP4 198.51.100.1 192.0.2.1 56324 80\r\nGET / HTTP/1.0\r\n\r\n")
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	if string(body) != "198.51.100.1:56324" {
+		t.Errorf("got remote address %q", body)
+	}
+}
@@ -0,0 +1,289 @@
+// See LICENSE file for copyright and license details
+
+package serve
+
+import (
+	"container/list"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"net/netip"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"djmo.ch/go-shrt/cmd/shrt/internal/base"
+)
+
+// A rateLimiter limits the rate of requests from each client, which
+// is the subnet of its address, using token buckets. Requests that
+// are answered with 404 Not Found draw on a separate budget from the
+// others, so that clients enumerating keys are refused long before
+// clients following links. Once a budget of a client is spent, its
+// requests of that kind are refused until it is replenished, while
+// the other budget is unaffected.
+type rateLimiter struct {
+	found, missing rate
+	v4Bits, v6Bits int
+	exempt         []netip.Prefix
+	maxClients     int
+	status         int
+	now            func() time.Time
+
+	mu      sync.Mutex
+	clients map[netip.Prefix]*list.Element // of *rateClient
+	lru     list.List                      // most recently seen first
+}
+
+// A rate allows n requests per period, in bursts of up to n. A zero
+// rate allows any number of requests.
+type rate struct {
+	n      float64
+	period time.Duration
+}
+
+// A bucket holds the tokens left to a client as of last.
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+type rateClient struct {
+	prefix         netip.Prefix
+	found, missing bucket
+}
+
+// rateLimiterFromEnv returns the rate limiter set in the environment,
+// or nil if rate limiting is disabled.
+func rateLimiterFromEnv() (*rateLimiter, error) {
+	var err error
+	rl := &rateLimiter{now: time.Now, clients: make(map[netip.Prefix]*list.Element)}
+	if rl.found, err = parseRate(base.SHRT_RATELIMIT); err != nil {
+		return nil, err
+	}
+	if rl.missing, err = parseRate(base.SHRT_RATELIMIT404); err != nil {
+		return nil, err
+	}
+	if rl.found.n == 0 && rl.missing.n == 0 {
+		return nil, nil
+	}
+
+	bits := strings.Split(os.Getenv(base.SHRT_RATESUBNET), ",")
+	if len(bits) != 2 {
+		return nil, fmt.Errorf("invalid %s: %q", base.SHRT_RATESUBNET, os.Getenv(base.SHRT_RATESUBNET))
+	}
+	rl.v4Bits, err = strconv.Atoi(strings.TrimSpace(bits[0]))
+	if err != nil || rl.v4Bits < 1 || rl.v4Bits > 32 {
+		return nil, fmt.Errorf("invalid %s: %q", base.SHRT_RATESUBNET, os.Getenv(base.SHRT_RATESUBNET))
+	}
+	rl.v6Bits, err = strconv.Atoi(strings.TrimSpace(bits[1]))
+	if err != nil || rl.v6Bits < 1 || rl.v6Bits > 128 {
+		return nil, fmt.Errorf("invalid %s: %q", base.SHRT_RATESUBNET, os.Getenv(base.SHRT_RATESUBNET))
+	}
+
+	for _, s := range strings.Fields(strings.ReplaceAll(os.Getenv(base.SHRT_RATEEXEMPT), ",", " ")) {
+		p, err := parsePrefix(s)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", base.SHRT_RATEEXEMPT, err)
+		}
+		rl.exempt = append(rl.exempt, p)
+	}
+
+	rl.maxClients, err = strconv.Atoi(os.Getenv(base.SHRT_RATECLIENTS))
+	if err != nil || rl.maxClients < 1 {
+		return nil, fmt.Errorf("invalid %s: %q", base.SHRT_RATECLIENTS, os.Getenv(base.SHRT_RATECLIENTS))
+	}
+	rl.status, err = strconv.Atoi(os.Getenv(base.SHRT_RATESTATUS))
+	if err != nil || rl.status < 400 || rl.status > 599 {
+		return nil, fmt.Errorf("invalid %s: %q", base.SHRT_RATESTATUS, os.Getenv(base.SHRT_RATESTATUS))
+	}
+	return rl, nil
+}
+
+// parseRate parses the rate in the environment variable key, which
+// is empty or 0 for no limit, or has the form n/s, n/m or n/h.
+func parseRate(key string) (rate, error) {
+	v := os.Getenv(key)
+	if v == "" || v == "0" {
+		return rate{}, nil
+	}
+	num, unit, _ := strings.Cut(v, "/")
+	n, err := strconv.ParseFloat(num, 64)
+	if err != nil || n < 1 || math.IsInf(n, 0) {
+		return rate{}, fmt.Errorf("invalid %s: %q", key, v)
+	}
+	switch unit {
+	case "s":
+		return rate{n, time.Second}, nil
+	case "m":
+		return rate{n, time.Minute}, nil
+	case "h":
+		return rate{n, time.Hour}, nil
+	}
+	return rate{}, fmt.Errorf("invalid %s: %q", key, v)
+}
+
+// parsePrefix parses a subnet in CIDR notation, or a single address.
+func parsePrefix(s string) (netip.Prefix, error) {
+	if strings.Contains(s, "/") {
+		p, err := netip.ParsePrefix(s)
+		return p.Masked(), err
+	}
+	addr, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	return netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()), nil
+}
+
+// handler returns a handler that serves requests to h within the
+// budgets of their clients, and refuses the others. Whether a request
+// draws on the budget for requests that find a link or for those that
+// do not is only known once h sets the status of the response, so the
+// response is replaced by a refusal at that point if that budget is
+// spent.
+func (rl *rateLimiter) handler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		client, ok := rl.client(req)
+		if !ok {
+			h.ServeHTTP(w, req)
+			return
+		}
+		lw := &limitWriter{ResponseWriter: w, rl: rl, client: client}
+		h.ServeHTTP(lw, req)
+		if !lw.decided {
+			lw.WriteHeader(http.StatusOK)
+		}
+	})
+}
+
+// A limitWriter takes a token from the budget of a client when the
+// status of the response written to it is set, and writes a refusal
+// instead of the response if there is none to take.
+type limitWriter struct {
+	http.ResponseWriter
+	rl      *rateLimiter
+	client  netip.Prefix
+	decided bool
+	refused bool
+}
+
+func (w *limitWriter) WriteHeader(status int) {
+	switch {
+	case w.refused:
+		return
+	case w.decided:
+		w.ResponseWriter.WriteHeader(status)
+		return
+	}
+	w.decided = true
+	wait := w.rl.take(w.client, status == http.StatusNotFound)
+	if wait == 0 {
+		w.ResponseWriter.WriteHeader(status)
+		return
+	}
+	w.refused = true
+	hdr := w.Header()
+	for k := range hdr {
+		delete(hdr, k)
+	}
+	hdr.Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+	http.Error(w.ResponseWriter, http.StatusText(w.rl.status), w.rl.status)
+}
+
+func (w *limitWriter) Write(p []byte) (int, error) {
+	if !w.decided {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.refused {
+		return len(p), nil
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+// client returns the client that sent req. It reports false if the
+// client is exempt or its address is unknown.
+func (rl *rateLimiter) client(req *http.Request) (netip.Prefix, bool) {
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		return netip.Prefix{}, false
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return netip.Prefix{}, false
+	}
+	addr = addr.Unmap().WithZone("")
+	for _, p := range rl.exempt {
+		if p.Contains(addr) {
+			return netip.Prefix{}, false
+		}
+	}
+	bits := rl.v6Bits
+	if addr.Is4() {
+		bits = rl.v4Bits
+	}
+	p, _ := addr.Prefix(bits)
+	return p, true
+}
+
+// take takes a token from the budget of client for requests that
+// found a link, or for those that did not if missing is true. If the
+// budget holds no whole token, it takes none and returns how long it
+// will be until it does.
+func (rl *rateLimiter) take(client netip.Prefix, missing bool) time.Duration {
+	r := rl.found
+	if missing {
+		r = rl.missing
+	}
+	if r.n == 0 {
+		return 0
+	}
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	now := rl.now()
+	var c *rateClient
+	if e, ok := rl.clients[client]; ok {
+		rl.lru.MoveToFront(e)
+		c = e.Value.(*rateClient)
+	} else {
+		if len(rl.clients) >= rl.maxClients {
+			oldest := rl.lru.Remove(rl.lru.Back()).(*rateClient)
+			delete(rl.clients, oldest.prefix)
+		}
+		c = &rateClient{
+			prefix:  client,
+			found:   bucket{rl.found.n, now},
+			missing: bucket{rl.missing.n, now},
+		}
+		rl.clients[client] = rl.lru.PushFront(c)
+	}
+	b := &c.found
+	if missing {
+		b = &c.missing
+	}
+	if wait := b.fill(r, now); wait > 0 {
+		return wait
+	}
+	b.tokens--
+	return 0
+}
+
+// fill adds the tokens earned at rate r since b was last filled, and
+// returns how long it will be until b holds a whole token.
+func (b *bucket) fill(r rate, now time.Time) time.Duration {
+	if r.n == 0 {
+		return 0
+	}
+	perToken := float64(r.period) / r.n
+	if elapsed := now.Sub(b.last); elapsed > 0 {
+		b.tokens = math.Min(r.n, b.tokens+float64(elapsed)/perToken)
+		b.last = now
+	}
+	if b.tokens >= 1 {
+		return 0
+	}
+	return time.Duration((1 - b.tokens) * perToken)
+}
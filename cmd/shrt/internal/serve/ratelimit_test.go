@@ -0,0 +1,128 @@
+// See LICENSE file for copyright and license details
+
+package serve
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+	"time"
+
+	"djmo.ch/go-shrt/cmd/shrt/internal/base"
+)
+
+func setRateEnv(t *testing.T, found, missing string) {
+	t.Helper()
+	t.Setenv(base.SHRT_RATELIMIT, found)
+	t.Setenv(base.SHRT_RATELIMIT404, missing)
+	t.Setenv(base.SHRT_RATESUBNET, "32,64")
+	t.Setenv(base.SHRT_RATEEXEMPT, "192.0.2.0/24, 2001:db8::1")
+	t.Setenv(base.SHRT_RATECLIENTS, "3")
+	t.Setenv(base.SHRT_RATESTATUS, "429")
+}
+
+func TestRateLimiterFromEnv(t *testing.T) {
+	setRateEnv(t, "", "0")
+	if rl, err := rateLimiterFromEnv(); rl != nil || err != nil {
+		t.Errorf("no limits: got %v, %v", rl, err)
+	}
+	for key, val := range map[string]string{
+		base.SHRT_RATELIMIT:    "10",
+		base.SHRT_RATELIMIT404: "0.5/s",
+		base.SHRT_RATESUBNET:   "24",
+		base.SHRT_RATEEXEMPT:   "192.0.2.0/33",
+		base.SHRT_RATECLIENTS:  "0",
+		base.SHRT_RATESTATUS:   "200",
+	} {
+		setRateEnv(t, "10/m", "5/m")
+		t.Setenv(key, val)
+		if _, err := rateLimiterFromEnv(); err == nil {
+			t.Errorf("%s=%q: no error", key, val)
+		}
+	}
+}
+
+func TestRateLimiter(t *testing.T) {
+	setRateEnv(t, "3/m", "1/m")
+	rl, err := rateLimiterFromEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Unix(0, 0)
+	rl.now = func() time.Time { return now }
+	h := rl.handler(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == "/missing" {
+			http.NotFound(w, req)
+			return
+		}
+		http.Redirect(w, req, "https://example.com/", http.StatusMovedPermanently)
+	}))
+	get := func(addr, path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", path, nil)
+		req.RemoteAddr = addr
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		return w
+	}
+	want := func(addr, path string, code int) {
+		t.Helper()
+		if got := get(addr, path).Code; got != code {
+			t.Errorf("%s %s: got status %d, want %d", addr, path, got, code)
+		}
+	}
+
+	// Budgets are spent separately, and each refuses only the
+	// requests that draw on it
+	want("198.51.100.1:1000", "/missing", http.StatusNotFound)
+	w := get("198.51.100.1:1000", "/missing")
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") != "60" {
+		t.Errorf("over 404 budget: got status %d, Retry-After %q", w.Code, w.Header().Get("Retry-After"))
+	}
+	if w.Header().Get("Location") != "" {
+		t.Error("refusal carries the header of the refused response")
+	}
+	for i := 0; i < 3; i++ {
+		want("198.51.100.1:1000", "/foo", http.StatusMovedPermanently)
+	}
+	w = get("198.51.100.1:1000", "/foo")
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") != "20" {
+		t.Errorf("over budget: got status %d, Retry-After %q", w.Code, w.Header().Get("Retry-After"))
+	}
+	want("198.51.100.1:1000", "/missing", http.StatusTooManyRequests)
+
+	// Other clients have their own budgets, shared within a subnet
+	for i := 0; i < 3; i++ {
+		want("[2001:db8:0:1::1]:1000", "/foo", http.StatusMovedPermanently)
+	}
+	want("[2001:db8:0:1::2]:1000", "/foo", http.StatusTooManyRequests)
+	want("[2001:db8:0:2::1]:1000", "/foo", http.StatusMovedPermanently)
+
+	// Budgets are replenished over time
+	now = now.Add(20 * time.Second)
+	w = get("198.51.100.1:1000", "/missing")
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") != "40" {
+		t.Errorf("replenishing: got status %d, Retry-After %q", w.Code, w.Header().Get("Retry-After"))
+	}
+	want("198.51.100.1:1000", "/foo", http.StatusMovedPermanently)
+	want("[2001:db8:0:1::1]:1000", "/foo", http.StatusMovedPermanently)
+	now = now.Add(40 * time.Second)
+	want("198.51.100.1:1000", "/missing", http.StatusNotFound)
+
+	// Exempt clients and unknown addresses are not limited
+	for i := 0; i < 5; i++ {
+		want("192.0.2.7:1000", "/missing", http.StatusNotFound)
+		want("[2001:db8::1]:1000", "/missing", http.StatusNotFound)
+		want("@", "/missing", http.StatusNotFound)
+	}
+
+	// At most SHRT_RATECLIENTS clients are remembered, forgetting
+	// the least recently seen
+	want("203.0.113.1:1000", "/foo", http.StatusMovedPermanently)
+	if n := len(rl.clients); n != 3 || rl.lru.Len() != 3 {
+		t.Errorf("tracking %d clients in map and %d in list, want 3", n, rl.lru.Len())
+	}
+	if _, ok := rl.clients[netip.MustParsePrefix("2001:db8:0:2::/64")]; ok {
+		t.Error("least recently seen client not forgotten")
+	}
+}
@@ -0,0 +1,113 @@
+// See LICENSE file for copyright and license details
+
+package serve
+
+import (
+	"errors"
+	"log"
+	"strings"
+	"time"
+
+	"djmo.ch/go-shrt"
+	"djmo.ch/go-shrt/cmd/shrt/internal/base"
+	"djmo.ch/go-shrt/cmd/shrt/internal/env"
+)
+
+// fixedEnv lists the environment variables whose values cannot
+// change while the server is running.
+var fixedEnv = []string{
+	base.SHRTENV,
+	base.SHRT_DBPATH,
+	base.SHRT_ADMINADDR,
+	base.SHRT_ADMINPREFIX,
+	base.SHRT_ADMINTOKENS,
+	base.SHRT_ADMINHTPASSWD,
+	base.SHRT_UIPREFIX,
+	base.SHRT_UISECRET,
+	base.SHRT_PIDFILE,
+	base.SHRT_KEYALPHABET,
+	base.SHRT_KEYLENGTH,
+	base.SHRT_KEYSTRATEGY,
+	base.SHRT_KEYBLOCKLIST,
+	base.SHRT_TLSCERT,
+	base.SHRT_TLSKEY,
+	base.SHRT_TLSREDIRECT,
+	base.SHRT_SOCKETMODE,
+	base.SHRT_SOCKETOWNER,
+	base.SHRT_DRAINTIMEOUT,
+	base.SHRT_READTIMEOUT,
+	base.SHRT_HEADERTIMEOUT,
+	base.SHRT_WRITETIMEOUT,
+	base.SHRT_IDLETIMEOUT,
+	base.SHRT_MAXHEADERBYTES,
+	base.SHRT_MAXCONNS,
+	base.SHRT_MAXPATHLEN,
+	base.SHRT_LOCKDOWN,
+	base.SHRT_CHROOT,
+	base.SHRT_USER,
+	base.SHRT_RATELIMIT,
+	base.SHRT_RATELIMIT404,
+	base.SHRT_RATESUBNET,
+	base.SHRT_RATEEXEMPT,
+	base.SHRT_RATECLIENTS,
+	base.SHRT_RATESTATUS,
+	base.SHRT_TRUSTEDPROXIES,
+	base.SHRT_PROXYPROTOCOL,
+}
+
+// reloadConfig re-reads the configuration and installs it in h. If
+// the configuration cannot be read or is invalid, the error is logged
+// and h keeps its current configuration.
+func reloadConfig(h *shrt.ShrtHandler) error {
+	cfg, changed, err := env.ReloadConfig(fixedEnv...)
+	if err == nil {
+		err = h.SetConfig(cfg)
+	}
+	if err != nil {
+		if errs, ok := err.(env.ErrorList); ok {
+			for _, e := range errs {
+				log.Println("config error:", e)
+			}
+		} else {
+			log.Println("config error:", err)
+		}
+		log.Println("config reload failed; keeping current configuration")
+		return err
+	}
+	if len(changed) == 0 {
+		log.Println("configuration unchanged")
+		return nil
+	}
+	log.Println("configuration changed:", strings.Join(changed, ", "))
+	h.Prepare()
+	return nil
+}
+
+// reload reloads the database served by h and logs the outcome. If
+// the reload fails, every error is logged and h continues to serve
+// the last good database.
+func reload(h *shrt.ShrtHandler) error {
+	err := h.Reload()
+	st := h.Status()
+	if err != nil {
+		logDbError(h.Config.DbPath, err)
+		log.Printf("reload failed; still serving generation %d loaded %s",
+			st.Generation, st.Loaded.Format(time.RFC3339))
+		return err
+	}
+	log.Printf("loaded %d entries (generation %d)", st.Entries, st.Generation)
+	return nil
+}
+
+// logDbError logs err, which was returned while reading the database
+// at dbPath. Each error in a shrt.ErrorList is logged separately.
+func logDbError(dbPath string, err error) {
+	var list shrt.ErrorList
+	if errors.As(err, &list) {
+		for _, e := range list {
+			log.Printf("db error: /%s: %s", dbPath, e)
+		}
+		return
+	}
+	log.Println("db error:", err)
+}
@@ -0,0 +1,46 @@
+// See LICENSE file for copyright and license details
+
+package serve
+
+import (
+	"net/http"
+	"strings"
+)
+
+// router dispatches requests to handlers mounted at path prefixes,
+// and sends requests matching no prefix to fallback.
+type router struct {
+	routes   []route
+	fallback http.Handler
+}
+
+type route struct {
+	prefix string // begins with, but does not end with, "/"
+	h      http.Handler
+}
+
+// mount routes requests for prefix, and paths beneath it, to h.
+func (r *router) mount(prefix string, h http.Handler) {
+	r.routes = append(r.routes, route{prefix: cleanPrefix(prefix), h: h})
+}
+
+func (r *router) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	p := req.URL.Path
+	for _, rt := range r.routes {
+		if p == rt.prefix || strings.HasPrefix(p, rt.prefix+"/") {
+			rt.h.ServeHTTP(w, req)
+			return
+		}
+	}
+	r.fallback.ServeHTTP(w, req)
+}
+
+// cleanPrefix returns prefix with a leading slash and no trailing
+// slash. The root prefix is returned as the empty string.
+func cleanPrefix(prefix string) string {
+	prefix = strings.Trim(prefix, "/")
+	if prefix == "" {
+		return ""
+	}
+	return "/" + prefix
+}
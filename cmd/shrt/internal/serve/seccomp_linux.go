@@ -0,0 +1,184 @@
+// See LICENSE file for copyright and license details
+
+package serve
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// Constants from linux/seccomp.h.
+const (
+	seccompSetModeFilter   = 1
+	seccompFilterFlagTsync = 1
+
+	seccompRetKillProcess = 0x80000000
+	seccompRetErrno       = 0x00050000
+	seccompRetAllow       = 0x7fff0000
+
+	// Offsets in struct seccomp_data
+	seccompDataNr   = 0
+	seccompDataArch = 4
+	seccompDataArgs = 16
+)
+
+// syscalls lists the system calls the server makes once it has
+// started, in addition to those in archSyscalls.
+var syscalls = []uintptr{
+	// Files
+	unix.SYS_READ,
+	unix.SYS_WRITE,
+	unix.SYS_READV,
+	unix.SYS_WRITEV,
+	unix.SYS_PREAD64,
+	unix.SYS_PWRITE64,
+	unix.SYS_OPENAT,
+	unix.SYS_CLOSE,
+	unix.SYS_LSEEK,
+	unix.SYS_FSTAT,
+	unix.SYS_STATX,
+	unix.SYS_GETDENTS64,
+	unix.SYS_READLINKAT,
+	unix.SYS_FACCESSAT,
+	unix.SYS_FACCESSAT2,
+	unix.SYS_GETCWD,
+	unix.SYS_FCNTL,
+	unix.SYS_DUP,
+	unix.SYS_DUP3,
+	unix.SYS_PIPE2,
+	unix.SYS_FLOCK,
+	unix.SYS_FSYNC,
+	unix.SYS_FDATASYNC,
+	unix.SYS_FTRUNCATE,
+	unix.SYS_FCHMOD,
+	unix.SYS_FCHMODAT,
+	unix.SYS_FCHOWN,
+	unix.SYS_FCHOWNAT,
+	unix.SYS_UTIMENSAT,
+	unix.SYS_RENAMEAT,
+	unix.SYS_RENAMEAT2,
+	unix.SYS_UNLINKAT,
+	unix.SYS_MKDIRAT,
+
+	// Sockets and polling
+	unix.SYS_SOCKET,
+	unix.SYS_CONNECT,
+	unix.SYS_ACCEPT4,
+	unix.SYS_SHUTDOWN,
+	unix.SYS_GETSOCKNAME,
+	unix.SYS_GETPEERNAME,
+	unix.SYS_SETSOCKOPT,
+	unix.SYS_GETSOCKOPT,
+	unix.SYS_SENDTO,
+	unix.SYS_RECVFROM,
+	unix.SYS_SENDMSG,
+	unix.SYS_RECVMSG,
+	unix.SYS_EPOLL_CREATE1,
+	unix.SYS_EPOLL_CTL,
+	unix.SYS_EPOLL_PWAIT,
+	unix.SYS_EPOLL_PWAIT2,
+	unix.SYS_EVENTFD2,
+	unix.SYS_PPOLL,
+	unix.SYS_PSELECT6,
+
+	// Memory, threads and signals
+	unix.SYS_MMAP,
+	unix.SYS_MUNMAP,
+	unix.SYS_MPROTECT,
+	unix.SYS_MADVISE,
+	unix.SYS_BRK,
+	unix.SYS_FUTEX,
+	unix.SYS_CLONE,
+	unix.SYS_CLONE3,
+	unix.SYS_SET_ROBUST_LIST,
+	unix.SYS_RSEQ,
+	unix.SYS_EXIT,
+	unix.SYS_EXIT_GROUP,
+	unix.SYS_SCHED_YIELD,
+	unix.SYS_SCHED_GETAFFINITY,
+	unix.SYS_GETPID,
+	unix.SYS_GETTID,
+	unix.SYS_GETUID,
+	unix.SYS_GETEUID,
+	unix.SYS_GETGID,
+	unix.SYS_GETEGID,
+	unix.SYS_RT_SIGACTION,
+	unix.SYS_RT_SIGPROCMASK,
+	unix.SYS_RT_SIGRETURN,
+	unix.SYS_SIGALTSTACK,
+	unix.SYS_RESTART_SYSCALL,
+
+	// Miscellaneous
+	unix.SYS_CLOCK_GETTIME,
+	unix.SYS_CLOCK_NANOSLEEP,
+	unix.SYS_NANOSLEEP,
+	unix.SYS_GETTIMEOFDAY,
+	unix.SYS_GETRANDOM,
+	unix.SYS_UNAME,
+	unix.SYS_PRLIMIT64,
+}
+
+// seccomp restricts the process to the system calls in syscalls and
+// archSyscalls, using a seccomp filter. Other system calls fail with
+// EPERM. Signals may only be sent to the threads of the process.
+func seccomp(access) error {
+	if auditArch == 0 {
+		return fmt.Errorf("seccomp %w on %s", errUnavailable, runtime.GOARCH)
+	}
+	filter := seccompFilter(append(archSyscalls, syscalls...), os.Getpid())
+	prog := unix.SockFprog{Len: uint16(len(filter)), Filter: &filter[0]}
+
+	// The filter is installed on every thread, which requires only
+	// the calling thread to have no_new_privs set.
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	if err := unix.Prctl(unix.PR_SET_NO_NEW_PRIVS, 1, 0, 0, 0); err != nil {
+		return fmt.Errorf("prctl: %v", err)
+	}
+	tid, _, errno := unix.Syscall(unix.SYS_SECCOMP, seccompSetModeFilter, seccompFilterFlagTsync, uintptr(unsafe.Pointer(&prog)))
+	switch {
+	case errno == unix.ENOSYS:
+		return fmt.Errorf("seccomp %w: %v", errUnavailable, errno)
+	case errno != 0:
+		return fmt.Errorf("seccomp: %v", errno)
+	case tid != 0:
+		return fmt.Errorf("seccomp: cannot synchronize thread %d", tid)
+	}
+	return nil
+}
+
+// seccompFilter returns a filter allowing the given system calls, and
+// tgkill to the threads of process pid.
+func seccompFilter(allowed []uintptr, pid int) []unix.SockFilter {
+	stmt := func(code uint16, k uint32) unix.SockFilter {
+		return unix.SockFilter{Code: code, K: k}
+	}
+	jeq := func(k uint32, jt, jf uint8) unix.SockFilter {
+		return unix.SockFilter{Code: unix.BPF_JMP | unix.BPF_JEQ | unix.BPF_K, Jt: jt, Jf: jf, K: k}
+	}
+	var (
+		load  = uint16(unix.BPF_LD | unix.BPF_W | unix.BPF_ABS)
+		ret   = uint16(unix.BPF_RET | unix.BPF_K)
+		allow = stmt(ret, seccompRetAllow)
+		deny  = stmt(ret, seccompRetErrno|uint32(unix.EPERM))
+	)
+	filter := []unix.SockFilter{
+		stmt(load, seccompDataArch),
+		jeq(auditArch, 1, 0),
+		stmt(ret, seccompRetKillProcess),
+		stmt(load, seccompDataNr),
+		jeq(unix.SYS_TGKILL, 0, 4),
+		stmt(load, seccompDataArgs), // low half of tgid
+		jeq(uint32(pid), 0, 1),
+		allow,
+		deny,
+	}
+	for _, nr := range allowed {
+		filter = append(filter, jeq(uint32(nr), 0, 1), allow)
+	}
+	return append(filter, deny)
+}
@@ -0,0 +1,27 @@
+// See LICENSE file for copyright and license details
+
+package serve
+
+import "golang.org/x/sys/unix"
+
+const auditArch = unix.AUDIT_ARCH_X86_64
+
+// archSyscalls lists the system calls made on amd64 only.
+var archSyscalls = []uintptr{
+	unix.SYS_OPEN,
+	unix.SYS_STAT,
+	unix.SYS_LSTAT,
+	unix.SYS_NEWFSTATAT,
+	unix.SYS_READLINK,
+	unix.SYS_ACCESS,
+	unix.SYS_RENAME,
+	unix.SYS_UNLINK,
+	unix.SYS_MKDIR,
+	unix.SYS_DUP2,
+	unix.SYS_PIPE,
+	unix.SYS_POLL,
+	unix.SYS_EPOLL_CREATE,
+	unix.SYS_EPOLL_WAIT,
+	unix.SYS_ARCH_PRCTL,
+	unix.SYS_GETRLIMIT,
+}
@@ -0,0 +1,13 @@
+// See LICENSE file for copyright and license details
+
+package serve
+
+import "golang.org/x/sys/unix"
+
+const auditArch = unix.AUDIT_ARCH_AARCH64
+
+// archSyscalls lists the system calls made on arm64 only.
+var archSyscalls = []uintptr{
+	unix.SYS_FSTATAT,
+	unix.SYS_GETRLIMIT,
+}
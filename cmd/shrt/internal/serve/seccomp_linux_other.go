@@ -0,0 +1,11 @@
+// See LICENSE file for copyright and license details
+
+//go:build linux && !amd64 && !arm64
+
+package serve
+
+// auditArch is zero on architectures without a list of system calls,
+// where seccomp is not used.
+const auditArch = 0
+
+var archSyscalls []uintptr
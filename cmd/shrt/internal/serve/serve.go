@@ -9,30 +9,152 @@ import (
 	"log"
 	"net"
 	"net/http"
+	"net/http/fcgi"
 	"net/url"
 	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
 
 	"djmo.ch/go-shrt"
 	"djmo.ch/go-shrt/cmd/shrt/internal/base"
+	"djmo.ch/go-shrt/cmd/shrt/internal/env"
 )
 
 var (
-	hangup   func(*shrt.ShrtHandler)
-	lockdown func(string)
+	hangup        func(reload func())
+	lockdown      func(access)
+	lockdownCheck func() error
 )
 
+// access describes the file system access the server needs once it
+// has started.
+type access struct {
+	read    []string // files and directories that are read
+	write   []string // directories whose files are created or replaced
+	sockets []string // Unix domain sockets that are created
+	remove  []string // other files that are removed on exit
+	unix    bool     // whether any listener is a Unix domain socket
+}
+
+// restrict enters the sandbox described by acc, unless it is
+// disabled by SHRT_LOCKDOWN or unsupported on this system.
+func restrict(acc access) {
+	if lockdown != nil && env.Enabled(os.Getenv(base.SHRT_LOCKDOWN)) {
+		lockdown(acc)
+	}
+}
+
+// checkLockdown returns an error if the sandbox is enabled by
+// SHRT_LOCKDOWN but cannot be entered by this build of shrt.
+func checkLockdown() error {
+	if lockdownCheck != nil && env.Enabled(os.Getenv(base.SHRT_LOCKDOWN)) {
+		return lockdownCheck()
+	}
+	return nil
+}
+
+// listening records that the server listens on l, which was returned
+// by listen for u.
+func (a *access) listening(l net.Listener, u *url.URL) {
+	if u.Scheme == "unix" || u.Scheme == "fcgi" && u.Host == "" {
+		a.sockets = append(a.sockets, u.Path)
+	}
+	if l.Addr().Network() == "unix" {
+		a.unix = true
+	}
+}
+
 var Cmd = &base.Command{
-	Run:       runServe,
 	Name:      "serve",
-	Usage:     "shrt serve URL",
+	Usage:     "shrt serve [-w interval] URL | cgi",
 	ShortHelp: "serve requests",
 	LongHelp: `Serve serves HTTP requests.
 
 Shrt listens and serves shortlinks and go-get requests on the provided
-URL. The only recognized scheme is http.
+URL, which uses the http, https, unix or fcgi scheme, or an inherited
+socket, as described in 'shrt help urls'. If URL is the word cgi,
+serve handles a single CGI request and exits.
+
+The server is hardened and tuned by the following settings, which are
+described in 'shrt help environment':
+
+	SHRT_DRAINTIMEOUT    graceful shutdown on SIGINT and SIGTERM
+	SHRT_MAXCONNS, ...   timeouts and limits on connections
+	SHRT_LOCKDOWN        sandbox using Landlock, seccomp or pledge(2)
+	SHRT_CHROOT          chroot before loading any files
+	SHRT_USER            user to switch to once listening
+	SHRT_RATELIMIT, ...  rate limits for each client
+	SHRT_TRUSTEDPROXIES  client addresses given by reverse proxies
+	SHRT_CANONICALHOST   redirection or refusal of other host names
+
+If NOTIFY_SOCKET is set, as for systemd services with Type=notify,
+serve reports its readiness, reloads and shutdown to it.
+
+On Unix systems, sending SIGHUP to the server reloads the
+configuration, the database and then the TLS certificate. The names of
+any changed environment variables are logged. The listen URL, SHRTENV,
+SHRT_DBPATH, SHRT_PIDFILE, the certificate paths, and the settings of
+the admin API, the web UI and the key generator cannot change while
+the server is running; changes to them are ignored with a warning. If
+the configuration is invalid, or the database cannot be read or
+contains errors, every error is logged and the server continues with
+the last good configuration and database. The outcome of the most
+recent database reload is reported as JSON at /.shrt/status. Errors at
+startup cause serve to exit with a non-zero status. If SHRT_PIDFILE is
+set, the process ID of the server is written to it at startup, so that
+'shrt add -r' and 'shrt rm -r' can signal it.
+
+The -w flag enables watch mode, which works on every platform. The
+database file is polled at the given interval (for example, 2s), and
+once a change has settled it is reloaded exactly as it would be by
+SIGHUP. Files replaced by renaming another file over them are
+detected. Only the database file itself is watched: the database
+format has no include directive, so there are no included files whose
+changes could trigger a reload.
+
+A QR code encoding the short URL https://SHRT_SRVNAME/KEY is served
+at /KEY.qr, or at /KEY?qr if KEY itself ends in .qr. The size, ec and
+format query parameters set the image size in pixels (at most 1024),
+the error correction level and the image format (png or svg), as
+described in 'shrt help qr'.
+
+If SHRT_ADMINADDR or SHRT_ADMINPREFIX is set, serve also provides an
+admin API for managing links, either on its own listener or beneath a
+path prefix on the main one. Requests must be authenticated with a
+bearer token from SHRT_ADMINTOKENS or a user from SHRT_ADMINHTPASSWD.
+The API offers the following endpoints, relative to the prefix:
+
+	GET    /links      list all entries
+	POST   /links      create an entry
+	GET    /links/KEY  get the entry for KEY
+	PUT    /links/KEY  create or replace the entry for KEY
+	DELETE /links/KEY  delete the entry for KEY
+	POST   /reload     reload the database
+
+Entries are JSON objects with "key", "type" and "url" members. An
+entry posted without a type is a shortlink, and one without a key is
+given a generated one, as described in 'shrt help add'. Changes are written to a temporary file that is
+renamed over the database, under a lock shared with other writers,
+and the database is then reloaded. Comments and hand-made changes are
+preserved.
+
+If SHRT_UIPREFIX is set, serve also provides a web UI at that prefix
+for browsing, adding, editing and deleting links. The UI shares its
+listener and users with the admin API, but requires
+SHRT_ADMINHTPASSWD, since browsers authenticate with a user name and
+password.
 	`,
 }
 
+var serveW = Cmd.Flags.Duration("w", 0, "")
+
+func init() {
+	// break init cycle
+	Cmd.Run = runServe
+}
+
 func runServe(ctx context.Context) {
 	log.SetFlags(log.LstdFlags)
 	log.SetPrefix("")
@@ -43,38 +165,226 @@ func runServe(ctx context.Context) {
 	if len(args) != 1 {
 		log.Fatal("no URL provided")
 	}
-	u, err := url.Parse(args[0])
+	if err := cfg.Validate(); err != nil {
+		log.Fatal("invalid configuration: ", err)
+	}
+	if args[0] == "cgi" {
+		serveCGI(cfg)
+		return
+	}
+
+	drainTimeout, err := time.ParseDuration(os.Getenv(base.SHRT_DRAINTIMEOUT))
+	if err != nil || drainTimeout < 0 {
+		log.Fatalf("invalid %s: %q", base.SHRT_DRAINTIMEOUT, os.Getenv(base.SHRT_DRAINTIMEOUT))
+	}
+	go exitOnSignal(drainTimeout)
+	lim, err := limitsFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+	px, err := proxiesFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+	limiter, err := rateLimiterFromEnv()
 	if err != nil {
-		log.Fatal("failed to parse URL: ", err)
+		log.Fatal(err)
+	}
+	jl, err := jailFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := jl.chroot(); err != nil {
+		log.Fatal(err)
+	}
+	if err := checkLockdown(); err != nil {
+		log.Fatal(err)
 	}
 
 	shrtfile := shrt.NewShrtFile()
 	fsys := os.DirFS("/").(fs.StatFS)
-	f, err := fsys.Open(cfg.DbPath)
-	if err != nil {
-		log.Println("fs error:", err)
+	h := &shrt.ShrtHandler{Config: cfg, ShrtFile: shrtfile, FS: fsys}
+	if err := h.Reload(); err != nil {
+		logDbError(cfg.DbPath, err)
 		os.Exit(1)
 	}
-	err = shrtfile.ReadShrtFile(f)
-	if err != nil {
-		log.Println("db error:", err)
-		os.Exit(1)
+	if pidfile := os.Getenv(base.SHRT_PIDFILE); pidfile != "" {
+		pid := strconv.Itoa(os.Getpid()) + "\n"
+		if err := os.WriteFile(pidfile, []byte(pid), 0644); err != nil {
+			log.Fatal(err)
+		}
+		atExit(func() { removePidfile(pidfile, pid) })
+	}
+	var certs *certLoader
+	if usesTLS(args[0], os.Getenv(base.SHRT_ADMINADDR)) {
+		var err error
+		if certs, err = newCertLoader(); err != nil {
+			fatal(err)
+		}
 	}
-	h := &shrt.ShrtHandler{Config: cfg, ShrtFile: shrtfile, FS: fsys}
 	if hangup != nil {
-		go hangup(h)
+		go hangup(func() {
+			if jl.reloadEnv {
+				reloadConfig(h)
+			}
+			reload(h)
+			if certs != nil {
+				certs.reload()
+			}
+			notify(readyStatus(h))
+		})
 	}
-	if lockdown != nil {
-		lockdown(cfg.DbPath)
+	if *serveW > 0 {
+		w := &shrt.Watcher{
+			FS:       fsys,
+			Name:     cfg.DbPath,
+			Interval: *serveW,
+			Reload:   func() error { return reload(h) },
+		}
+		log.Println("watching", "/"+cfg.DbPath, "for changes every", *serveW)
+		go w.Run(ctx)
 	}
-	switch u.Scheme {
-	case "http":
-		listener, err := net.Listen("tcp", u.Host)
+
+	var (
+		root = &router{fallback: h}
+		acc  = access{read: []string{"/" + cfg.DbPath}}
+	)
+	if limiter != nil {
+		root.fallback = limiter.handler(h)
+	}
+	if envFile := os.Getenv(base.SHRTENV); envFile != "" && jl.reloadEnv {
+		acc.read = append(acc.read, envFile) // reread on SIGHUP
+	}
+	if certs != nil {
+		acc.read = append(acc.read, certs.certFile, certs.keyFile)
+	}
+	if pidfile := os.Getenv(base.SHRT_PIDFILE); pidfile != "" {
+		acc.remove = append(acc.remove, pidfile)
+	}
+	admin, ui, err := adminHandlers(h)
+	if err != nil {
+		fatal(err)
+	}
+	if admin != nil || ui != nil {
+		acc.write = append(acc.write, filepath.Dir("/"+cfg.DbPath))
+		r := root
+		addr := os.Getenv(base.SHRT_ADMINADDR)
+		if addr != "" {
+			r = &router{fallback: http.NotFoundHandler()}
+		}
+		if ui != nil {
+			r.mount(ui.Prefix, ui)
+			log.Println("serving web UI at", addr+ui.Prefix)
+		}
+		if admin != nil {
+			r.mount(admin.Prefix, admin)
+			log.Println("serving admin API at", addr+admin.Prefix)
+		}
+		if addr != "" {
+			listener, u := listen(addr)
+			acc.listening(listener, u)
+			go func() { fatal(serve(listener, u, r, certs, lim, px)) }()
+		}
+	}
+
+	listener, u := listen(args[0])
+	acc.listening(listener, u)
+	if redirect := os.Getenv(base.SHRT_TLSREDIRECT); redirect != "" {
+		if protocol(u) != "https" {
+			fatalf("%s requires an https URL", base.SHRT_TLSREDIRECT)
+		}
+		rl, ru := listen(redirect)
+		acc.listening(rl, ru)
+		if protocol(ru) != "http" {
+			fatalf("%s must be an http URL", base.SHRT_TLSREDIRECT)
+		}
+		log.Println("redirecting", redirect, "to https")
+		go func() { fatal(serve(rl, ru, httpsRedirect(cfg.SrvName, u.Port()), nil, lim, px)) }()
+	}
+	if err := jl.drop(); err != nil {
+		fatal(err)
+	}
+	notify("READY=1", readyStatus(h))
+	restrict(acc)
+	fatal(serve(listener, u, root, certs, lim, px))
+}
+
+// listen returns a listener for the URL rawURL, along with the
+// parsed URL.
+func listen(rawURL string) (net.Listener, *url.URL) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		fatal("failed to parse URL: ", err)
+	}
+	var listener net.Listener
+	if strings.Contains(u.Scheme, "+") {
+		switch protocol(u) {
+		case "http", "https", "fcgi":
+			listener, err = listenInherited(u)
+		default:
+			fatal("unknown scheme: ", u.Scheme)
+		}
 		if err != nil {
-			log.Fatal("listen: ", err)
+			fatal("listen: ", err)
+		}
+		return listener, u
+	}
+	switch u.Scheme {
+	case "http", "https":
+		listener, err = net.Listen("tcp", u.Host)
+	case "unix":
+		listener, err = listenUnix(u)
+	case "fcgi":
+		if u.Host == "" {
+			listener, err = listenUnix(u)
+		} else {
+			listener, err = net.Listen("tcp", u.Host)
 		}
-		log.Fatal(http.Serve(listener, h))
 	default:
-		log.Fatal("unknown scheme:", u.Scheme)
+		fatal("unknown scheme: ", u.Scheme)
+	}
+	if err != nil {
+		fatal("listen: ", err)
+	}
+	return listener, u
+}
+
+// serve serves HTTP requests to handler on listener, which was
+// returned by listen for u, subject to lim and trusting px.
+// Connections to https URLs use TLS with the certificate held by
+// certs, and connections to fcgi URLs use FastCGI.
+func serve(listener net.Listener, u *url.URL, handler http.Handler, certs *certLoader, lim *limits, px *proxies) error {
+	handler = track(lim.handler(px.handler(handler)))
+	listener = lim.listener(listener)
+	if protocol(u) == "fcgi" {
+		atStop(func(context.Context) error { return listener.Close() })
+		return fcgi.Serve(listener, handler)
+	}
+	listener = px.listener(listener)
+	srv := lim.server(handler)
+	atStop(srv.Shutdown)
+	if protocol(u) == "https" {
+		srv.TLSConfig = certs.tlsConfig()
+		return srv.ServeTLS(listener, "", "")
+	}
+	return srv.Serve(listener)
+}
+
+// removePidfile removes the pidfile at path if it still contains
+// pid, so that the pidfile of another server is left alone.
+func removePidfile(path, pid string) {
+	if data, err := os.ReadFile(path); err == nil && string(data) == pid {
+		os.Remove(path)
+	}
+}
+
+// usesTLS reports whether any of the listen URLs uses the https
+// scheme.
+func usesTLS(urls ...string) bool {
+	for _, rawURL := range urls {
+		if u, err := url.Parse(rawURL); err == nil && protocol(u) == "https" {
+			return true
+		}
 	}
+	return false
 }
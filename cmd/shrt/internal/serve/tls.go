@@ -0,0 +1,107 @@
+// See LICENSE file for copyright and license details
+
+package serve
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync/atomic"
+
+	"djmo.ch/go-shrt/cmd/shrt/internal/base"
+)
+
+// A certLoader holds the certificate served on https listeners, and
+// replaces it when asked to reload.
+type certLoader struct {
+	certFile, keyFile string
+	cert              atomic.Value // *tls.Certificate
+}
+
+// newCertLoader returns a certLoader for the certificate and key
+// named by SHRT_TLSCERT and SHRT_TLSKEY, which is loaded before
+// returning.
+func newCertLoader() (*certLoader, error) {
+	l := &certLoader{
+		certFile: os.Getenv(base.SHRT_TLSCERT),
+		keyFile:  os.Getenv(base.SHRT_TLSKEY),
+	}
+	if l.certFile == "" || l.keyFile == "" {
+		return nil, fmt.Errorf("https requires %s and %s", base.SHRT_TLSCERT, base.SHRT_TLSKEY)
+	}
+	if err := l.load(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+func (l *certLoader) load() error {
+	cert, err := tls.LoadX509KeyPair(l.certFile, l.keyFile)
+	if err != nil {
+		return err
+	}
+	l.cert.Store(&cert)
+	return nil
+}
+
+// reload reloads the certificate and logs the outcome. If the
+// certificate cannot be loaded, the current one continues to be
+// served.
+func (l *certLoader) reload() {
+	if err := l.load(); err != nil {
+		log.Println("tls error:", err)
+		log.Println("certificate reload failed; keeping current certificate")
+		return
+	}
+	log.Println("reloaded certificate", l.certFile)
+}
+
+func (l *certLoader) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	cert, ok := l.cert.Load().(*tls.Certificate)
+	if !ok {
+		return nil, errors.New("no certificate loaded")
+	}
+	return cert, nil
+}
+
+// tlsConfig returns the TLS configuration of https listeners. TLS
+// versions before 1.2 are disabled; otherwise the defaults of the
+// crypto/tls package, which track current recommendations, are used.
+func (l *certLoader) tlsConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: l.getCertificate,
+	}
+}
+
+// httpsRedirect redirects every request to the same path on the
+// https listener at port. The host name of the request is kept, or
+// srvName is used if the request has none.
+func httpsRedirect(srvName, port string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		host := req.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		host = strings.TrimSuffix(strings.TrimPrefix(host, "["), "]")
+		if host == "" {
+			host = srvName
+		}
+		switch {
+		case port != "" && port != "443":
+			host = net.JoinHostPort(host, port)
+		case strings.Contains(host, ":"):
+			host = "[" + host + "]"
+		}
+		code := http.StatusPermanentRedirect
+		if req.Method == http.MethodGet || req.Method == http.MethodHead {
+			code = http.StatusMovedPermanently
+		}
+		http.Redirect(w, req, "https://"+host+req.URL.RequestURI(), code)
+	})
+}
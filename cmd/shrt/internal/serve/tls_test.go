@@ -0,0 +1,124 @@
+// See LICENSE file for copyright and license details
+
+package serve
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"djmo.ch/go-shrt/cmd/shrt/internal/base"
+)
+
+// writeCert writes a self-signed certificate for name and its key to
+// certFile and keyFile.
+func writeCert(t *testing.T, name, certFile, keyFile string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: name},
+		DNSNames:     []string{name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	kder, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: kder})
+	if err := os.WriteFile(certFile, certPEM, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, keyPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func servedName(t *testing.T, l *certLoader) string {
+	t.Helper()
+	cert, err := l.getCertificate(&tls.ClientHelloInfo{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return leaf.Subject.CommonName
+}
+
+func TestCertLoader(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	t.Setenv(base.SHRT_TLSCERT, certFile)
+	t.Setenv(base.SHRT_TLSKEY, "")
+	if _, err := newCertLoader(); err == nil {
+		t.Fatal("newCertLoader succeeded without a key")
+	}
+
+	t.Setenv(base.SHRT_TLSKEY, keyFile)
+	writeCert(t, "one.example.org", certFile, keyFile)
+	l, err := newCertLoader()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg := l.tlsConfig(); cfg.MinVersion != tls.VersionTLS12 {
+		t.Errorf("got MinVersion %#x", cfg.MinVersion)
+	}
+
+	writeCert(t, "two.example.org", certFile, keyFile)
+	l.reload()
+	if name := servedName(t, l); name != "two.example.org" {
+		t.Errorf("after reload: got certificate for %s", name)
+	}
+
+	// A broken certificate leaves the current one in place
+	os.WriteFile(certFile, []byte("garbage"), 0644)
+	l.reload()
+	if name := servedName(t, l); name != "two.example.org" {
+		t.Errorf("after failed reload: got certificate for %s", name)
+	}
+}
+
+func TestHTTPSRedirect(t *testing.T) {
+	tests := []struct {
+		method, host, target, port string
+		code                       int
+		want                       string
+	}{
+		{"GET", "example.org", "/foo?x=1", "443", http.StatusMovedPermanently, "https://example.org/foo?x=1"},
+		{"GET", "example.org:80", "/foo", "8443", http.StatusMovedPermanently, "https://example.org:8443/foo"},
+		{"POST", "[::1]:80", "/", "", http.StatusPermanentRedirect, "https://[::1]/"},
+		{"HEAD", "", "/bar", "443", http.StatusMovedPermanently, "https://srv.example.org/bar"},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(tt.method, tt.target, nil)
+		req.Host = tt.host
+		w := httptest.NewRecorder()
+		httpsRedirect("srv.example.org", tt.port).ServeHTTP(w, req)
+		if w.Code != tt.code || w.Header().Get("Location") != tt.want {
+			t.Errorf("%s %s%s: got %d %s, want %d %s", tt.method, tt.host, tt.target,
+				w.Code, w.Header().Get("Location"), tt.code, tt.want)
+		}
+	}
+}
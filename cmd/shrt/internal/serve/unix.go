@@ -0,0 +1,118 @@
+// See LICENSE file for copyright and license details
+
+package serve
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"net"
+	"net/url"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"djmo.ch/go-shrt/cmd/shrt/internal/base"
+)
+
+// listenUnix returns a listener on the Unix domain socket named by
+// the path of u. A stale socket left by a server that did not exit
+// cleanly is removed first. The socket is given the permissions and
+// owner in SHRT_SOCKETMODE and SHRT_SOCKETOWNER, and is removed when
+// the server exits.
+func listenUnix(u *url.URL) (net.Listener, error) {
+	path := u.Path
+	if u.Host != "" || !filepath.IsAbs(path) {
+		return nil, fmt.Errorf("unix URL must name an absolute path: %s", u)
+	}
+	mode, err := strconv.ParseUint(os.Getenv(base.SHRT_SOCKETMODE), 8, 32)
+	if err != nil || mode&^0777 != 0 {
+		return nil, fmt.Errorf("invalid %s: %q", base.SHRT_SOCKETMODE, os.Getenv(base.SHRT_SOCKETMODE))
+	}
+	uid, gid, err := ownerIDs(base.SHRT_SOCKETOWNER, os.Getenv(base.SHRT_SOCKETOWNER))
+	if err != nil {
+		return nil, err
+	}
+
+	if err := removeStale(path); err != nil {
+		return nil, err
+	}
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	atExit(func() { listener.Close() }) // removes the socket
+	if err := os.Chmod(path, fs.FileMode(mode)); err != nil {
+		return nil, err
+	}
+	if uid != -1 || gid != -1 {
+		if err := os.Chown(path, uid, gid); err != nil {
+			return nil, err
+		}
+	}
+	return listener, nil
+}
+
+// removeStale removes the socket at path if no server is listening
+// on it. Files that are not sockets are never removed.
+func removeStale(path string) error {
+	fi, err := os.Lstat(path)
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		return nil
+	case err != nil:
+		return err
+	case fi.Mode()&fs.ModeSocket == 0:
+		return fmt.Errorf("%s exists and is not a socket", path)
+	}
+	if conn, err := net.Dial("unix", path); err == nil {
+		conn.Close()
+		return fmt.Errorf("%s is in use by another server", path)
+	}
+	log.Println("removing stale socket", path)
+	return os.Remove(path)
+}
+
+// ownerIDs returns the user and group IDs named by owner, the value
+// of the environment variable key, which has the form user,
+// user:group or :group. An ID of -1 means the user or group is left
+// unchanged.
+func ownerIDs(key, owner string) (int, int, error) {
+	uid, gid := -1, -1
+	if owner == "" {
+		return uid, gid, nil
+	}
+	name, group, _ := strings.Cut(owner, ":")
+	if name != "" {
+		id := name
+		if _, err := strconv.Atoi(name); err != nil {
+			u, err := user.Lookup(name)
+			if err != nil {
+				return 0, 0, fmt.Errorf("%s: %s", key, err)
+			}
+			id = u.Uid
+		}
+		var err error
+		if uid, err = strconv.Atoi(id); err != nil {
+			return 0, 0, fmt.Errorf("%s: user %s has no numeric ID", key, name)
+		}
+	}
+	if group != "" {
+		id := group
+		if _, err := strconv.Atoi(group); err != nil {
+			g, err := user.LookupGroup(group)
+			if err != nil {
+				return 0, 0, fmt.Errorf("%s: %s", key, err)
+			}
+			id = g.Gid
+		}
+		var err error
+		if gid, err = strconv.Atoi(id); err != nil {
+			return 0, 0, fmt.Errorf("%s: group %s has no numeric ID", key, group)
+		}
+	}
+	return uid, gid, nil
+}
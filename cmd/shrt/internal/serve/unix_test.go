@@ -0,0 +1,84 @@
+// See LICENSE file for copyright and license details
+
+//go:build unix
+
+package serve
+
+import (
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"djmo.ch/go-shrt/cmd/shrt/internal/base"
+)
+
+func TestListenUnix(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "shrt.sock")
+	u := &url.URL{Scheme: "unix", Path: path}
+	t.Setenv(base.SHRT_SOCKETMODE, "0600")
+	t.Setenv(base.SHRT_SOCKETOWNER, "")
+
+	// A stale socket is removed
+	stale, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	stale.Close()
+	l, err := listenUnix(u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi, err := os.Stat(path); err != nil || fi.Mode().Perm() != 0600 {
+		t.Errorf("got %v, %v; want mode 0600", fi.Mode(), err)
+	}
+
+	// A socket in use is not
+	if _, err := listenUnix(u); err == nil {
+		t.Error("listened on a socket in use")
+	}
+	l.Close()
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("socket not removed on close: %v", err)
+	}
+
+	// Neither is a regular file
+	if err := os.WriteFile(path, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := listenUnix(u); err == nil {
+		t.Error("replaced a regular file")
+	}
+
+	if _, err := listenUnix(&url.URL{Scheme: "unix", Opaque: "shrt.sock"}); err == nil {
+		t.Error("listened on a relative path")
+	}
+	t.Setenv(base.SHRT_SOCKETMODE, "999")
+	if _, err := listenUnix(&url.URL{Scheme: "unix", Path: filepath.Join(dir, "other.sock")}); err == nil {
+		t.Error("accepted an invalid mode")
+	}
+}
+
+func TestSocketOwner(t *testing.T) {
+	tests := []struct {
+		owner    string
+		uid, gid int
+	}{
+		{"", -1, -1},
+		{"1000", 1000, -1},
+		{":50", -1, 50},
+		{"0:0", 0, 0},
+	}
+	for _, tt := range tests {
+		uid, gid, err := ownerIDs(base.SHRT_SOCKETOWNER, tt.owner)
+		if err != nil || uid != tt.uid || gid != tt.gid {
+			t.Errorf("ownerIDs(%q) = %d, %d, %v; want %d, %d", tt.owner, uid, gid, err, tt.uid, tt.gid)
+		}
+	}
+	if _, _, err := ownerIDs(base.SHRT_SOCKETOWNER, "no-such-user-shrt"); err == nil {
+		t.Error("found a nonexistent user")
+	}
+}
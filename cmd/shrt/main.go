@@ -12,8 +12,11 @@ import (
 	"os"
 
 	"djmo.ch/go-shrt/cmd/shrt/internal/base"
+	"djmo.ch/go-shrt/cmd/shrt/internal/check"
 	"djmo.ch/go-shrt/cmd/shrt/internal/env"
 	"djmo.ch/go-shrt/cmd/shrt/internal/help"
+	"djmo.ch/go-shrt/cmd/shrt/internal/links"
+	"djmo.ch/go-shrt/cmd/shrt/internal/probe"
 	"djmo.ch/go-shrt/cmd/shrt/internal/serve"
 	"djmo.ch/go-shrt/cmd/shrt/internal/version"
 )
@@ -21,10 +24,18 @@ import (
 func init() {
 	base.Shrt.Subcommands = []*base.Command{
 		serve.Cmd,
+		links.AddCmd,
+		links.RmCmd,
+		links.LsCmd,
+		links.ShowCmd,
+		links.QRCmd,
+		check.Cmd,
+		probe.Cmd,
 		env.Cmd,
 		version.Cmd,
 
 		help.EnvCmd,
+		help.URLsCmd,
 	}
 }
 
@@ -0,0 +1,161 @@
+// See LICENSE file for copyright and license details
+
+package shrt
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+var shrtTmpl = template.Must(template.New("shrt").Parse(shrtrsp))
+
+// compiled holds the responses pre-rendered from one Snapshot of the
+// database. A compiled value is never modified after it is
+// published, so it may be read without locking. If entries is nil,
+// responses are rendered from the Snapshot on demand.
+type compiled struct {
+	snap         *Snapshot
+	cfg          *Config
+	modtime      time.Time
+	etag         string
+	lastModified string
+	entries      map[string]*response
+}
+
+// response is a pre-rendered response for a single ShrtFile entry.
+// For go-get entries, body holds the page served when the request
+// path is exactly the key.
+type response struct {
+	entry  ShrtEntry
+	header http.Header
+	body   []byte
+}
+
+// Prepare pre-renders the responses for the current Snapshot of the
+// ShrtFile and the current configuration. ServeHTTP arranges for
+// this to happen in the background when it notices either has
+// changed, rendering responses on demand in the meantime. Calling
+// Prepare directly after a reload avoids that interval.
+func (s *ShrtHandler) Prepare() {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.compile(s.ShrtFile.Snapshot(), s.config())
+}
+
+// responses returns the responses for the current Snapshot of the
+// database and the current configuration. It never blocks: if the
+// pre-rendered responses are out of date, compilation is started in
+// the background, unless the handler is Lazy, and responses are
+// rendered on demand until it completes.
+func (s *ShrtHandler) responses() *compiled {
+	snap, cs := s.ShrtFile.Snapshot(), s.config()
+	c, _ := s.compiled.Load().(*compiled)
+	if c != nil && c.snap == snap && c.cfg == &cs.cfg {
+		return c
+	}
+	c = newCompiled(snap, cs)
+	if !s.Lazy && s.mux.TryLock() {
+		s.compiled.Store(c)
+		go func() {
+			defer s.mux.Unlock()
+			s.compile(snap, cs)
+		}()
+	}
+	return c
+}
+
+// compile pre-renders every entry in snap and publishes the result.
+// The caller must hold s.mux.
+func (s *ShrtHandler) compile(snap *Snapshot, cs *configState) {
+	c, _ := s.compiled.Load().(*compiled)
+	if c != nil && c.snap == snap && c.cfg == &cs.cfg && c.entries != nil {
+		return
+	}
+	c = newCompiled(snap, cs)
+	entries := make(map[string]*response, snap.Len())
+	for key, val := range snap.m {
+		entries[key] = c.render(key, val)
+	}
+	c.entries = entries
+	s.compiled.Store(c)
+}
+
+// newCompiled returns a compiled with no pre-rendered entries. Its
+// validators change whenever either the database or the
+// configuration does.
+func newCompiled(snap *Snapshot, cs *configState) *compiled {
+	modtime := snap.Loaded
+	if cs.set.After(modtime) {
+		modtime = cs.set
+	}
+	return &compiled{
+		snap:         snap,
+		cfg:          &cs.cfg,
+		modtime:      modtime,
+		etag:         fmt.Sprintf(`"%x-%x"`, snap.Generation, modtime.UnixNano()),
+		lastModified: modtime.UTC().Format(http.TimeFormat),
+	}
+}
+
+// lookup returns the response for key, rendering it if it has not
+// been pre-rendered.
+func (c *compiled) lookup(key string) (*response, bool) {
+	if c.entries != nil {
+		rsp, ok := c.entries[key]
+		return rsp, ok
+	}
+	val, err := c.snap.Get(key)
+	if err != nil {
+		return nil, false
+	}
+	return c.render(key, val), true
+}
+
+func (c *compiled) render(key string, val ShrtEntry) *response {
+	rsp := &response{
+		entry: val,
+		header: http.Header{
+			"Etag":          {c.etag},
+			"Last-Modified": {c.lastModified},
+		},
+	}
+	switch val.Type {
+	case ShortLink:
+		rsp.header.Set("Location", val.URL)
+		setHeader(rsp.header, "Cache-Control", c.cfg.CacheShortLink)
+		rsp.body = []byte("Redirecting\n")
+	case GoGet:
+		setHeader(rsp.header, "Cache-Control", c.cfg.CacheGoGet)
+		rsp.header.Set("Content-Type", "text/html; charset=utf-8")
+		// On error, body is left nil and the page is rendered
+		// per request instead
+		var buf bytes.Buffer
+		if err := shrtTmpl.Execute(&buf, goGetRequest(c.cfg, key, key, val)); err == nil {
+			rsp.body = buf.Bytes()
+			rsp.header.Set("Content-Length", strconv.Itoa(len(rsp.body)))
+		}
+	}
+	return rsp
+}
+
+func goGetRequest(cfg *Config, key, p string, val ShrtEntry) shrtRequest {
+	return shrtRequest{
+		SrvName:      cfg.SrvName,
+		Repo:         key,
+		ScmType:      cfg.ScmType,
+		URL:          val.URL,
+		DocPath:      p,
+		GoSourceDir:  cfg.GoSourceDir,
+		GoSourceFile: cfg.GoSourceFile,
+	}
+}
+
+func setHeader(h http.Header, key, value string) {
+	if value != "" {
+		h.Set(key, value)
+	}
+}
@@ -0,0 +1,273 @@
+// See LICENSE file for copyright and license details
+
+package shrt
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// An Editor makes changes to the entries in a database file. Comments,
+// blank lines, and the order and formatting of unchanged entries are
+// preserved, so that changes made with an Editor can coexist with
+// changes made by hand. An Editor is obtained from [EditShrtFile].
+type Editor struct {
+	lines   []string
+	keys    map[string]int // index into lines
+	entries map[string]ShrtEntry
+	changed bool
+}
+
+// newEditor returns an Editor for the ShrtFile data. If data
+// contains errors, they are returned as an ErrorList.
+func newEditor(data []byte) (*Editor, error) {
+	e := &Editor{entries: make(map[string]ShrtEntry)}
+	if len(data) > 0 {
+		e.lines = strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+	}
+	var errs ErrorList
+	for i, text := range e.lines {
+		if isComment(text) {
+			continue
+		}
+		key, val, err := parseLine(text)
+		if err == nil {
+			if _, ok := e.entries[key]; ok {
+				err = fmt.Errorf("repeat key: %s", key)
+			}
+		}
+		if err != nil {
+			errs = append(errs, &SyntaxError{Line: i + 1, Msg: err.Error()})
+			continue
+		}
+		e.entries[key] = val
+	}
+	if len(errs) > 0 {
+		return nil, errs
+	}
+	e.index()
+	return e, nil
+}
+
+func (e *Editor) index() {
+	e.keys = make(map[string]int, len(e.entries))
+	for i, text := range e.lines {
+		if isComment(text) {
+			continue
+		}
+		key, _, _ := parseLine(text)
+		e.keys[key] = i
+	}
+}
+
+// Get returns the entry for key and reports whether it exists.
+func (e *Editor) Get(key string) (ShrtEntry, bool) {
+	entry, ok := e.entries[key]
+	return entry, ok
+}
+
+// Keys returns the keys of all entries in sorted order.
+func (e *Editor) Keys() []string {
+	keys := make([]string, 0, len(e.entries))
+	for k := range e.entries {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Set creates or replaces the entry for key. An existing entry is
+// replaced in place; a new entry is added to the end of the file.
+func (e *Editor) Set(key string, entry ShrtEntry) error {
+	if err := CheckKey(key); err != nil {
+		return err
+	}
+	if strings.ContainsAny(entry.URL, "\r\n") {
+		return fmt.Errorf("URL contains a line break: %q", entry.URL)
+	}
+	text := FormatEntry(key, entry)
+	k, val, err := parseLine(text)
+	if err != nil {
+		return err
+	}
+	if k != key || val != entry {
+		return fmt.Errorf("invalid URL: %q", entry.URL)
+	}
+	if i, ok := e.keys[key]; ok {
+		e.lines[i] = text
+	} else {
+		e.keys[key] = len(e.lines)
+		e.lines = append(e.lines, text)
+	}
+	e.entries[key] = entry
+	e.changed = true
+	return nil
+}
+
+// Delete removes the entry for key, along with the comment lines
+// directly above it, and reports whether the entry existed.
+func (e *Editor) Delete(key string) bool {
+	i, ok := e.keys[key]
+	if !ok {
+		return false
+	}
+	start := i
+	for start > 0 && strings.HasPrefix(strings.TrimSpace(e.lines[start-1]), "#") {
+		start--
+	}
+	e.lines = append(e.lines[:start], e.lines[i+1:]...)
+	delete(e.entries, key)
+	e.index()
+	e.changed = true
+	return true
+}
+
+// Annotate replaces the comment lines directly above the entry for
+// key whose text begins with tag by a single comment line containing
+// tag and text, and reports whether the entry exists. If text is
+// empty, the comment lines are removed and none is added. Other
+// comment lines are left untouched. Runs of white space in text,
+// including line breaks, are replaced by a single space.
+//
+// Tags made of lower case letters, digits and hyphens and ending in
+// a colon, such as "probe:", mark the comment as an annotation, which
+// is not part of the description of the entry.
+func (e *Editor) Annotate(key, tag, text string) bool {
+	i, ok := e.keys[key]
+	if !ok {
+		return false
+	}
+	start := i
+	for start > 0 && strings.HasPrefix(strings.TrimSpace(e.lines[start-1]), "#") {
+		start--
+	}
+	var lines []string
+	for _, line := range e.lines[start:i] {
+		comment := strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(line), "#"))
+		if !strings.HasPrefix(comment, tag) {
+			lines = append(lines, line)
+		}
+	}
+	if text = strings.Join(strings.Fields(text), " "); text != "" {
+		lines = append(lines, "# "+tag+" "+text)
+	}
+	if strings.Join(lines, "\n") == strings.Join(e.lines[start:i], "\n") {
+		return true
+	}
+	lines = append(lines, e.lines[i:]...)
+	e.lines = append(e.lines[:start], lines...)
+	e.index()
+	e.changed = true
+	return true
+}
+
+// Bytes returns the contents of the edited file.
+func (e *Editor) Bytes() []byte {
+	var buf bytes.Buffer
+	for _, text := range e.lines {
+		buf.WriteString(text)
+		buf.WriteByte('\n')
+	}
+	return buf.Bytes()
+}
+
+// CheckKey reports whether key can be used as the key of a new
+// ShrtFile entry. A usable key is a single, non-empty path element
+// that can be written to a ShrtFile and read back unchanged.
+func CheckKey(key string) error {
+	switch {
+	case key == "":
+		return errors.New("empty key")
+	case strings.ContainsAny(key, "/=#?% \t\r\n"):
+		return fmt.Errorf("key contains a reserved character: %q", key)
+	case key == "." || key == "..":
+		return fmt.Errorf("invalid key: %q", key)
+	}
+	return nil
+}
+
+// FormatEntry returns the ShrtFile line for an entry.
+func FormatEntry(key string, entry ShrtEntry) string {
+	return fmt.Sprintf("%s=%s:%s", key, entry.Type, entry.URL)
+}
+
+// EditShrtFile calls fn with an Editor for the database file at path,
+// which is an operating system path. If fn returns nil after making
+// changes, they are written to a temporary file in the same directory,
+// which is then renamed over path, so readers see either the old file
+// or the new one and never a partial write. A missing file is treated
+// as empty. If the file contains errors, fn is not called and the
+// errors are returned as an ErrorList.
+//
+// The file is locked while fn runs, so concurrent calls, including
+// calls from other processes, are serialized. The lock is taken on a
+// separate file whose name is path with ".lock" appended.
+func EditShrtFile(path string, fn func(*Editor) error) error {
+	unlock, err := lockFile(path + ".lock")
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	mode := fs.FileMode(0644)
+	data, err := os.ReadFile(path)
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+	case err != nil:
+		return err
+	default:
+		if fi, err := os.Stat(path); err == nil {
+			mode = fi.Mode().Perm()
+		}
+	}
+
+	e, err := newEditor(data)
+	if err != nil {
+		return err
+	}
+	if err := fn(e); err != nil {
+		return err
+	}
+	if !e.changed {
+		return nil
+	}
+	return writeFileAtomic(path, e.Bytes(), mode)
+}
+
+// writeFileAtomic replaces the file at path with data by way of a
+// temporary file in the same directory.
+func writeFileAtomic(path string, data []byte, mode fs.FileMode) error {
+	dir, name := filepath.Split(path)
+	if dir == "" {
+		dir = "."
+	}
+	f, err := os.CreateTemp(dir, "."+name+".*")
+	if err != nil {
+		return err
+	}
+	tmp := f.Name()
+	defer os.Remove(tmp) // fails harmlessly after a successful rename
+
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Chmod(mode); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
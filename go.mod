@@ -1,5 +1,8 @@
 module djmo.ch/go-shrt
 
-go 1.15
+go 1.19
 
-require golang.org/x/sys v0.13.0
+require (
+	golang.org/x/crypto v0.14.0
+	golang.org/x/sys v0.13.0
+)
@@ -0,0 +1,63 @@
+// See LICENSE file for copyright and license details
+
+package shrt
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// requestHost returns the host name to which req was sent, in lower
+// case, and its port, if any.
+func requestHost(req *http.Request) (host, port string) {
+	host = req.Host
+	if h, p, err := net.SplitHostPort(host); err == nil {
+		host, port = h, p
+	}
+	host = strings.TrimSuffix(strings.TrimPrefix(host, "["), "]")
+	return strings.ToLower(strings.TrimSuffix(host, ".")), port
+}
+
+// matchHost reports whether the host name host matches name.
+func matchHost(host, name string) bool {
+	name = strings.TrimSuffix(strings.TrimPrefix(name, "["), "]")
+	return host == strings.ToLower(strings.TrimSuffix(name, "."))
+}
+
+// serveHost redirects req to the canonical host if it was sent to one
+// of cfg.AltHosts, and refuses it if it was sent to any other host
+// but cfg.SrvName. It reports whether req was handled.
+func serveHost(w http.ResponseWriter, req *http.Request, cfg *Config) bool {
+	host, port := requestHost(req)
+	if matchHost(host, cfg.SrvName) {
+		return false
+	}
+	for _, alt := range cfg.AltHosts {
+		if !matchHost(host, alt) {
+			continue
+		}
+		scheme := req.URL.Scheme
+		if scheme == "" {
+			scheme = "http"
+			if req.TLS != nil {
+				scheme = "https"
+			}
+		}
+		target := cfg.SrvName
+		if port != "" {
+			target = net.JoinHostPort(target, port)
+		}
+		log.Printf("redirecting request for %s to %s", req.Host, cfg.SrvName)
+		w.Header().Set("Location", scheme+"://"+target+req.URL.RequestURI())
+		w.WriteHeader(http.StatusMovedPermanently)
+		fmt.Fprintln(w, "Redirecting")
+		return true
+	}
+	log.Printf("refusing request for unknown host %q", req.Host)
+	w.WriteHeader(http.StatusMisdirectedRequest)
+	fmt.Fprintln(w, "Misdirected request")
+	return true
+}
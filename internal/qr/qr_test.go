@@ -0,0 +1,145 @@
+// See LICENSE file for copyright and license details
+
+package qr
+
+import (
+	"bytes"
+	"errors"
+	"image/png"
+	"strings"
+	"testing"
+)
+
+func TestEncode(t *testing.T) {
+	want := []string{
+		"#######.##..##....#######",
+		"#.....#.#..#..#.#.#.....#",
+		"#.###.#.#.#.##....#.###.#",
+		"#.###.#..###.#.##.#.###.#",
+		"#.###.#.#.#...#.#.#.###.#",
+		"#.....#..##..##.#.#.....#",
+		"#######.#.#.#.#.#.#######",
+		"..........#.##.##........",
+		"#..########.#...##..#.###",
+		"#..##....#.#.#####.#####.",
+		"#.#.#.###.#.##.###.###..#",
+		"...#....#...#.#..###.####",
+		"#.######.#.##..##.##....#",
+		"#....#...##..####...#..#.",
+		"#####.#######.###.#.#####",
+		"#.#.##.#.###..#..###.##.#",
+		"#.#...###....#..#####.##.",
+		"........#..###..#...#.##.",
+		"#######.####....#.#.#...#",
+		"#.....#.#.#.##.##...#..#.",
+		"#.###.#.##..#.#######..##",
+		"#.###.#.###..##.###....##",
+		"#.###.#..#..##.#.#..#####",
+		"#.....#..#....##...##.###",
+		"#######.#...###.#.#..#..#",
+	}
+	c, err := Encode("https://example.org/foo", M)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Version != 2 || c.Size != len(want) {
+		t.Fatalf("got version %d, size %d; want 2, %d", c.Version, c.Size, len(want))
+	}
+	for y, row := range want {
+		var got strings.Builder
+		for x := range row {
+			if c.Dark(x, y) {
+				got.WriteByte('#')
+			} else {
+				got.WriteByte('.')
+			}
+		}
+		if got.String() != row {
+			t.Errorf("row %d: got %s, want %s", y, got.String(), row)
+		}
+	}
+}
+
+func TestCapacity(t *testing.T) {
+	tests := []struct {
+		n       int
+		level   Level
+		version int
+	}{
+		{17, L, 1},
+		{18, L, 2},
+		{14, M, 1},
+		{7, H, 1},
+		{271, L, 10},
+		{2331, M, 40},
+		{2953, L, 40},
+	}
+	for _, tt := range tests {
+		c, err := Encode(strings.Repeat("a", tt.n), tt.level)
+		if err != nil {
+			t.Errorf("%d bytes at %v: %s", tt.n, tt.level, err)
+			continue
+		}
+		if c.Version != tt.version || c.Size != 4*tt.version+17 {
+			t.Errorf("%d bytes at %v: got version %d, size %d; want %d", tt.n, tt.level, c.Version, c.Size, tt.version)
+		}
+	}
+	if _, err := Encode(strings.Repeat("a", 2954), L); !errors.Is(err, ErrTooLong) {
+		t.Errorf("2954 bytes: got %v, want ErrTooLong", err)
+	}
+}
+
+func TestReedSolomon(t *testing.T) {
+	// The version 1-M code for "HELLO WORLD"
+	data := []byte{32, 91, 11, 120, 209, 114, 220, 77, 67, 64, 236, 17, 236, 17, 236, 17}
+	want := []byte{196, 35, 39, 119, 235, 215, 231, 226, 93, 23}
+	if got := rsRemainder(data, rsDivisor(len(want))); !bytes.Equal(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestParseLevel(t *testing.T) {
+	for _, s := range []string{"L", "m", "Q", "h"} {
+		l, err := ParseLevel(s)
+		if err != nil || l.String() != strings.ToUpper(s) {
+			t.Errorf("ParseLevel(%q) = %v, %v", s, l, err)
+		}
+	}
+	if _, err := ParseLevel("X"); err == nil {
+		t.Error("ParseLevel(\"X\") succeeded")
+	}
+}
+
+func TestRender(t *testing.T) {
+	c, err := Encode("https://example.org/foo", M)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := c.WritePNG(&buf, 100); err != nil {
+		t.Fatal(err)
+	}
+	img, err := png.Decode(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b := img.Bounds(); b.Dx() != 100 || b.Dy() != 100 {
+		t.Errorf("got %v image, want 100x100", b)
+	}
+	// 100 pixels fit 3 pixels per module with a margin of 12
+	if r, _, _, _ := img.At(12, 12).RGBA(); r != 0 {
+		t.Error("top left module is light")
+	}
+	if r, _, _, _ := img.At(11, 11).RGBA(); r == 0 {
+		t.Error("quiet zone is dark")
+	}
+
+	buf.Reset()
+	if err := c.WriteSVG(&buf, 100); err != nil {
+		t.Fatal(err)
+	}
+	svg := buf.String()
+	if !strings.Contains(svg, `width="100" height="100" viewBox="0 0 33 33"`) || !strings.Contains(svg, "M4 4h7v1h-7z") {
+		t.Errorf("unexpected SVG:\n%s", svg)
+	}
+}
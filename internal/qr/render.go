@@ -0,0 +1,89 @@
+// See LICENSE file for copyright and license details
+
+package qr
+
+import (
+	"bufio"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+)
+
+// QuietZone is the width, in modules, of the light border required
+// around a code.
+const QuietZone = 4
+
+// scale returns the number of pixels per module and the margin, in
+// pixels, of an image of the code that is size pixels wide. The
+// margin includes the quiet zone. Images smaller than one pixel per
+// module are enlarged.
+func (c *Code) scale(size int) (int, int, int) {
+	n := c.Size + 2*QuietZone
+	if size < n {
+		size = n
+	}
+	px := size / n
+	return size, px, (size - c.Size*px) / 2
+}
+
+// Image returns the code as an image that is size pixels wide and
+// high, or as large as necessary to give each module one pixel.
+// Modules are drawn as squares of a whole number of pixels, centered
+// in the image and surrounded by at least the quiet zone.
+func (c *Code) Image(size int) image.Image {
+	size, px, margin := c.scale(size)
+	img := image.NewPaletted(image.Rect(0, 0, size, size), color.Palette{color.White, color.Black})
+	for y := 0; y < c.Size; y++ {
+		for x := 0; x < c.Size; x++ {
+			if !c.Dark(x, y) {
+				continue
+			}
+			for dy := 0; dy < px; dy++ {
+				off := img.PixOffset(margin+x*px, margin+y*px+dy)
+				for dx := 0; dx < px; dx++ {
+					img.Pix[off+dx] = 1
+				}
+			}
+		}
+	}
+	return img
+}
+
+// WritePNG writes the code to w as a PNG image of the given size, as
+// described for Image.
+func (c *Code) WritePNG(w io.Writer, size int) error {
+	enc := png.Encoder{CompressionLevel: png.BestCompression}
+	return enc.Encode(w, c.Image(size))
+}
+
+// WriteSVG writes the code to w as an SVG image that is size pixels
+// wide and high. The image scales without loss, so the modules need
+// not be a whole number of pixels wide.
+func (c *Code) WriteSVG(w io.Writer, size int) error {
+	n := c.Size + 2*QuietZone
+	if size < n {
+		size = n
+	}
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d" shape-rendering="crispEdges">`+"\n", size, size, n, n)
+	fmt.Fprintf(bw, `<rect width="%d" height="%d" fill="#fff"/>`+"\n", n, n)
+	bw.WriteString(`<path fill="#000" d="`)
+	for y := 0; y < c.Size; y++ {
+		for x := 0; x < c.Size; x++ {
+			if !c.Dark(x, y) {
+				continue
+			}
+			// Draw each horizontal run of dark modules as one rectangle
+			run := 1
+			for c.Dark(x+run, y) {
+				run++
+			}
+			fmt.Fprintf(bw, "M%d %dh%dv1h-%dz", x+QuietZone, y+QuietZone, run, run)
+			x += run - 1
+		}
+	}
+	bw.WriteString("\"/>\n</svg>\n")
+	return bw.Flush()
+}
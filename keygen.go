@@ -0,0 +1,169 @@
+// See LICENSE file for copyright and license details
+
+package shrt
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"strings"
+)
+
+const (
+	// DefaultKeyAlphabet is the alphabet used by a KeyGenerator
+	// with no Alphabet. It contains no vowels, so that generated
+	// keys do not spell words, and no characters that are easily
+	// confused with one another, such as 0 and o or 1 and l.
+	DefaultKeyAlphabet = "23456789bcdfghjkmnpqrstvwxyz"
+	// DefaultKeyLength is the length of the keys generated by a
+	// KeyGenerator with no Length.
+	DefaultKeyLength = 6
+)
+
+// maxKeyAttempts is the number of keys a KeyGenerator tries before
+// giving up.
+const maxKeyAttempts = 100
+
+// maxHashKeyLength is the length of the longest hashed key, which
+// uses no more than the 256 bits of the hash with an alphabet of up
+// to 256 characters.
+const maxHashKeyLength = 32
+
+// A KeyGenerator generates keys for new ShrtFile entries. The zero
+// KeyGenerator generates random keys of DefaultKeyLength characters
+// from DefaultKeyAlphabet.
+type KeyGenerator struct {
+	// Alphabet lists the characters that may appear in keys.
+	Alphabet string
+	// Length is the number of characters in each key.
+	Length int
+	// Hash selects keys derived from a SHA-256 hash of the URL
+	// instead of random keys, so that the same URL is given the
+	// same key on every server, unless that key is taken.
+	Hash bool
+	// Blocklist lists strings that never appear in generated keys,
+	// regardless of case.
+	Blocklist []string
+}
+
+// Check reports whether the generator's settings are usable.
+func (g *KeyGenerator) Check() error {
+	alphabet, length := g.settings()
+	switch {
+	case length < 1:
+		return fmt.Errorf("key length must be positive: %d", length)
+	case len(alphabet) < 2:
+		return errors.New("key alphabet must have at least two characters")
+	case g.Hash && length > maxHashKeyLength:
+		return fmt.Errorf("hashed keys are limited to %d characters", maxHashKeyLength)
+	}
+	seen := make(map[rune]bool)
+	for _, r := range alphabet {
+		if seen[r] {
+			return fmt.Errorf("key alphabet repeats %q", r)
+		}
+		seen[r] = true
+	}
+	if err := CheckKey(string(alphabet)); err != nil {
+		return fmt.Errorf("key alphabet: %s", err)
+	}
+	return nil
+}
+
+func (g *KeyGenerator) settings() ([]rune, int) {
+	alphabet, length := g.Alphabet, g.Length
+	if alphabet == "" {
+		alphabet = DefaultKeyAlphabet
+	}
+	if length == 0 {
+		length = DefaultKeyLength
+	}
+	return []rune(alphabet), length
+}
+
+// Generate returns a new key for an entry with the given URL. Keys
+// that contain a string in the Blocklist, or for which taken returns
+// true, are skipped. If no usable key is found after a number of
+// attempts, an error is returned; a longer Length or larger Alphabet
+// makes this less likely.
+func (g *KeyGenerator) Generate(url string, taken func(key string) bool) (string, error) {
+	if err := g.Check(); err != nil {
+		return "", err
+	}
+	alphabet, length := g.settings()
+	for i := 0; i < maxKeyAttempts; i++ {
+		var (
+			key string
+			err error
+		)
+		if g.Hash {
+			key = g.hashKey(alphabet, length, url, i)
+		} else {
+			key, err = g.randomKey(alphabet, length)
+		}
+		if err != nil {
+			return "", err
+		}
+		if !g.blocked(key) && (taken == nil || !taken(key)) {
+			return key, nil
+		}
+	}
+	return "", fmt.Errorf("no free key found after %d attempts", maxKeyAttempts)
+}
+
+func (g *KeyGenerator) randomKey(alphabet []rune, length int) (string, error) {
+	var (
+		b   strings.Builder
+		max = big.NewInt(int64(len(alphabet)))
+	)
+	for i := 0; i < length; i++ {
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", err
+		}
+		b.WriteRune(alphabet[n.Int64()])
+	}
+	return b.String(), nil
+}
+
+// hashKey returns the key for url on the given attempt. The key is
+// the base-len(alphabet) representation of the hash of url and
+// attempt, truncated to length.
+func (g *KeyGenerator) hashKey(alphabet []rune, length int, url string, attempt int) string {
+	h := sha256.New()
+	h.Write([]byte(url))
+	if attempt > 0 {
+		binary.Write(h, binary.BigEndian, uint32(attempt))
+	}
+	var (
+		b    strings.Builder
+		n    = new(big.Int).SetBytes(h.Sum(nil))
+		base = big.NewInt(int64(len(alphabet)))
+		rem  = new(big.Int)
+	)
+	for i := 0; i < length; i++ {
+		n.DivMod(n, base, rem)
+		b.WriteRune(alphabet[rem.Int64()])
+	}
+	return b.String()
+}
+
+// ReadBlocklist reads a KeyGenerator Blocklist from r, one string per
+// line. Blank lines and lines beginning with '#' are ignored.
+func ReadBlocklist(r io.Reader) ([]string, error) {
+	return readLines(r)
+}
+
+func (g *KeyGenerator) blocked(key string) bool {
+	key = strings.ToLower(key)
+	for _, s := range g.Blocklist {
+		if s != "" && strings.Contains(key, strings.ToLower(s)) {
+			return true
+		}
+	}
+	return false
+}
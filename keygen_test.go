@@ -0,0 +1,51 @@
+// See LICENSE file for copyright and license details
+
+package shrt
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestKeyGenerator(t *testing.T) {
+	var g KeyGenerator
+	key, err := g.Generate("https://example.com/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(key) != DefaultKeyLength || strings.Trim(key, DefaultKeyAlphabet) != "" {
+		t.Errorf("got key %q, want %d characters from %q", key, DefaultKeyLength, DefaultKeyAlphabet)
+	}
+
+	g = KeyGenerator{Alphabet: "ab", Length: 3, Hash: true}
+	first, err := g.Generate("https://example.com/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again, _ := g.Generate("https://example.com/", nil); again != first {
+		t.Errorf("hashed keys differ: %q and %q", first, again)
+	}
+	taken := map[string]bool{first: true}
+	second, err := g.Generate("https://example.com/", func(k string) bool { return taken[k] })
+	if err != nil || second == first {
+		t.Errorf("got %q, %v after %q was taken", second, err, first)
+	}
+
+	// Every key of length 2 from "ab" is blocked or taken.
+	g = KeyGenerator{Alphabet: "ab", Length: 2, Blocklist: []string{"A"}}
+	if key, err := g.Generate("x", func(k string) bool { return k == "bb" }); err == nil {
+		t.Errorf("got key %q, want error", key)
+	}
+
+	for _, g := range []KeyGenerator{
+		{Alphabet: "a"},
+		{Alphabet: "aba"},
+		{Alphabet: "ab/"},
+		{Length: -1},
+		{Length: 40, Hash: true},
+	} {
+		if err := g.Check(); err == nil {
+			t.Errorf("%+v: no error", g)
+		}
+	}
+}
@@ -0,0 +1,121 @@
+// See LICENSE file for copyright and license details
+
+package shrt
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+)
+
+// A Warning describes a ShrtFile entry that is valid but probably
+// not what was intended.
+type Warning struct {
+	Line int
+	Key  string
+	Msg  string
+}
+
+func (w *Warning) String() string {
+	return fmt.Sprintf("line %d: %s: %s", w.Line, w.Key, w.Msg)
+}
+
+// Lint reads a ShrtFile from r and reports suspicious entries when
+// served with cfg. Lint warns about entries whose URLs do not use the
+// http or https schemes, entries whose keys conflict with the
+// request paths that ShrtHandler reserves or with the additional
+// request paths in reserved, entries sharing a target with an earlier
+// entry, and go-get entries whose URLs look like repositories of an
+// SCM type other than cfg.ScmType.
+//
+// If the ShrtFile contains errors, they are returned as an ErrorList,
+// exactly as ReadShrtFile would report them, and no warnings are
+// returned.
+func Lint(r io.Reader, cfg Config, reserved ...string) ([]*Warning, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if _, _, err := parseShrtFile(bytes.NewReader(data)); err != nil {
+		return nil, err
+	}
+
+	shadowed := map[string]string{"robots.txt": "/robots.txt"}
+	for _, p := range append([]string{"/" + statusPath}, reserved...) {
+		key := strings.SplitN(strings.TrimPrefix(p, "/"), "/", 2)[0]
+		if key != "" {
+			shadowed[key] = p
+		}
+	}
+
+	var (
+		warnings []*Warning
+		targets  = make(map[string]string)
+		lines    = make(map[string]int)
+		line     = 0
+		scnr     = bufio.NewScanner(bytes.NewReader(data))
+	)
+	for scnr.Scan() {
+		line++
+		if isComment(scnr.Text()) {
+			continue
+		}
+		key, val, _ := parseLine(scnr.Text())
+		warn := func(format string, args ...interface{}) {
+			warnings = append(warnings, &Warning{Line: line, Key: key, Msg: fmt.Sprintf(format, args...)})
+		}
+
+		u, _ := url.Parse(val.URL) // checked by parseShrtFile
+		switch {
+		case u.Scheme == "":
+			warn("URL has no scheme: %s", val.URL)
+		case u.Scheme != "http" && u.Scheme != "https":
+			warn("URL scheme is not http or https: %s", val.URL)
+		}
+		if p, ok := shadowed[key]; ok {
+			warn("key conflicts with the reserved path %s", p)
+		}
+		if other, ok := targets[val.URL]; ok {
+			warn("same target as %s on line %d", other, lines[other])
+		} else {
+			targets[val.URL] = key
+		}
+		if val.Type == GoGet {
+			if scm := guessScm(u); scm != "" && scm != cfg.ScmType {
+				warn("URL looks like a %s repository, but the SCM type is %s", scm, cfg.ScmType)
+			}
+		}
+		lines[key] = line
+	}
+	return warnings, scnr.Err()
+}
+
+// guessScm returns the SCM type suggested by a repository URL, or
+// the empty string if there is no suggestion.
+func guessScm(u *url.URL) string {
+	host := strings.ToLower(u.Hostname())
+	p := strings.ToLower(strings.TrimSuffix(u.Path, "/"))
+	switch {
+	case strings.HasSuffix(p, ".git"), strings.HasPrefix(u.Scheme, "git+"):
+		return "git"
+	case strings.HasSuffix(p, ".hg"), host == "hg.sr.ht":
+		return "hg"
+	case strings.HasSuffix(p, ".bzr"), strings.HasPrefix(u.Scheme, "bzr+"):
+		return "bzr"
+	case strings.HasPrefix(u.Scheme, "svn"):
+		return "svn"
+	}
+	switch host {
+	case "github.com", "gitlab.com", "codeberg.org", "git.sr.ht", "bitbucket.org":
+		return "git"
+	}
+	for _, scm := range []string{"git", "hg", "svn", "fossil"} {
+		if strings.HasPrefix(host, scm+".") {
+			return scm
+		}
+	}
+	return ""
+}
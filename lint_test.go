@@ -0,0 +1,55 @@
+// See LICENSE file for copyright and license details
+
+package shrt
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestLint(t *testing.T) {
+	db := `# Links
+foo=shrtlnk:https://example.com/foo
+mail=shrtlnk:mailto:me@example.com
+rel=shrtlnk:/foo
+robots.txt=shrtlnk:https://example.com/robots
+admin=shrtlnk:https://example.com/admin
+bar=shrtlnk:https://example.com/foo
+mod=goget:https://github.com/user/mod
+hgmod=goget:https://hg.sr.ht/~user/hgmod
+gitmod=goget:https://example.com/gitmod.git
+`
+	cfg := Config{ScmType: "git"}
+	warnings, err := Lint(strings.NewReader(db), cfg, "/admin/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, w := range warnings {
+		got = append(got, w.String())
+	}
+	want := []string{
+		"line 3: mail: URL scheme is not http or https: mailto:me@example.com",
+		"line 4: rel: URL has no scheme: /foo",
+		"line 5: robots.txt: key conflicts with the reserved path /robots.txt",
+		"line 6: admin: key conflicts with the reserved path /admin/",
+		"line 7: bar: same target as foo on line 2",
+		"line 9: hgmod: URL looks like a hg repository, but the SCM type is git",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("got warnings:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+
+	cfg.ScmType = "hg"
+	warnings, _ = Lint(strings.NewReader("mod=goget:https://github.com/user/mod\n"), cfg)
+	if len(warnings) != 1 || !strings.Contains(warnings[0].Msg, "git repository") {
+		t.Errorf("got warnings %v for a git URL with SCM type hg", warnings)
+	}
+
+	_, err = Lint(strings.NewReader("foo\nbar=nope:x\n"), cfg)
+	var list ErrorList
+	if !errors.As(err, &list) || len(list) != 2 {
+		t.Errorf("got error %v, want 2 syntax errors", err)
+	}
+}
@@ -0,0 +1,11 @@
+// See LICENSE file for copyright and license details
+
+//go:build !(aix || darwin || dragonfly || freebsd || linux || netbsd || openbsd || windows)
+
+package shrt
+
+// lockFile does nothing on systems without file locking. Concurrent
+// edits are not serialized on these systems.
+func lockFile(path string) (func(), error) {
+	return func() {}, nil
+}
@@ -0,0 +1,95 @@
+// See LICENSE file for copyright and license details
+
+package shrt
+
+import (
+	"html/template"
+	"log"
+	"net/http"
+	"strings"
+)
+
+var previewTmpl = template.Must(template.New("preview").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<meta name="robots" content="noindex">
+<title>{{ .SrvName }}/{{ .Key }}</title>
+<style>
+body { font-family: sans-serif; margin: 2em auto; max-width: 50em; padding: 0 1em; }
+dt { font-weight: bold; margin-top: .5em; }
+dd { margin-left: 1em; word-break: break-all; }
+</style>
+</head>
+<body>
+<h1>{{ .SrvName }}/{{ .Key }}</h1>
+{{ if .Description }}<p>{{ .Description }}</p>
+{{ end }}<dl>
+{{ if .GoGet }}<dt>Type</dt><dd>Go import path</dd>
+<dt>Repository</dt><dd><a href="{{ .URL }}" rel="noreferrer nofollow">{{ .URL }}</a></dd>
+<dt>go-import</dt><dd><code>{{ .SrvName }}/{{ .Key }} {{ .ScmType }} {{ .URL }}</code></dd>
+{{ if .GoSourceDir }}<dt>go-source</dt><dd><code>{{ .SrvName }}/{{ .Key }} {{ .URL }} {{ .URL }}/{{ .GoSourceDir }} {{ .URL }}/{{ .GoSourceFile }}</code></dd>
+{{ end }}<dt>Documentation</dt><dd><a href="https://pkg.go.dev/{{ .SrvName }}/{{ .Key }}">pkg.go.dev/{{ .SrvName }}/{{ .Key }}</a></dd>
+{{ else }}<dt>Type</dt><dd>Shortlink</dd>
+<dt>Target</dt><dd><a href="{{ .URL }}" rel="noreferrer nofollow">{{ .URL }}</a></dd>
+{{ end }}</dl>
+</body>
+</html>
+`))
+
+type previewPage struct {
+	Key          string
+	Description  string
+	GoGet        bool
+	SrvName      string
+	ScmType      string
+	URL          string
+	GoSourceDir  string
+	GoSourceFile string
+}
+
+// previewKey reports whether req, whose path is the single element
+// p, requests a preview, and returns the key of the entry to preview.
+// A path ending in "+" requests a preview unless it is itself a key.
+func previewKey(c *compiled, req *http.Request, p string) (string, bool) {
+	if strings.HasSuffix(p, "+") {
+		if _, ok := c.lookup(p); !ok {
+			return strings.TrimSuffix(p, "+"), true
+		}
+	}
+	if strings.Contains(req.URL.RawQuery, "preview") {
+		q := req.URL.Query()
+		if _, ok := q["preview"]; ok && q.Get("go-get") != "1" {
+			return p, true
+		}
+	}
+	return "", false
+}
+
+// servePreview writes the preview page for key.
+func servePreview(w http.ResponseWriter, c *compiled, key string) {
+	val, err := c.snap.Get(key)
+	if err != nil {
+		log.Println("not found:", key)
+		notFound(w, c.cfg.CacheNotFound)
+		return
+	}
+	log.Println("preview request for", key)
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Etag", c.etag)
+	w.Header().Set("Last-Modified", c.lastModified)
+	err = previewTmpl.Execute(w, previewPage{
+		Key:          key,
+		Description:  c.snap.Description(key),
+		GoGet:        val.Type == GoGet,
+		SrvName:      c.cfg.SrvName,
+		ScmType:      c.cfg.ScmType,
+		URL:          val.URL,
+		GoSourceDir:  c.cfg.GoSourceDir,
+		GoSourceFile: c.cfg.GoSourceFile,
+	})
+	if err != nil {
+		log.Println("error executing template:", err)
+	}
+}
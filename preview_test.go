@@ -0,0 +1,64 @@
+// See LICENSE file for copyright and license details
+
+package shrt
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestPreview(t *testing.T) {
+	db := "# The foo\n# probe: dead as of 2026-01-02: 404 Not Found\n# home page\n" +
+		"foo=shrtlnk:https://example.com/foo\n" +
+		"c++=shrtlnk:https://example.com/cpp\n\n# Unrelated\n\n" +
+		"bar=goget:https://example.com/bar\n"
+	h := &ShrtHandler{
+		ShrtFile: NewShrtFile(),
+		FS:       fstest.MapFS{"shrt.db": &fstest.MapFile{Data: []byte(db)}},
+		Config:   Config{SrvName: "example.org", ScmType: "git", DbPath: "shrt.db", Preview: true},
+	}
+	if err := h.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if d := h.ShrtFile.Snapshot().Description("foo"); d != "The foo home page" {
+		t.Errorf("got description %q", d)
+	}
+	if d := h.ShrtFile.Snapshot().Description("bar"); d != "" {
+		t.Errorf("got description %q for bar, want none", d)
+	}
+
+	tests := []struct {
+		path     string
+		code     int
+		contains string
+	}{
+		{"/foo+", http.StatusOK, "<p>The foo home page</p>"},
+		{"/foo?preview", http.StatusOK, `href="https://example.com/foo"`},
+		{"/foo", http.StatusMovedPermanently, ""},
+		{"/c++", http.StatusMovedPermanently, ""},
+		{"/c+++", http.StatusOK, "example.org/c&#43;&#43;"},
+		{"/bar+", http.StatusOK, "example.org/bar git https://example.com/bar"},
+		{"/bar?preview&go-get=1", http.StatusOK, `<meta name="go-import"`},
+		{"/baz+", http.StatusNotFound, ""},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, tt.path, nil))
+		if w.Code != tt.code || !strings.Contains(w.Body.String(), tt.contains) {
+			t.Errorf("%s: got status %d, want %d and %q:\n%s", tt.path, w.Code, tt.code, tt.contains, w.Body)
+		}
+		if strings.Contains(w.Body.String(), "probe:") {
+			t.Errorf("%s: annotation shown in preview:\n%s", tt.path, w.Body)
+		}
+	}
+
+	h.SetConfig(Config{SrvName: "example.org", ScmType: "git", DbPath: "shrt.db"})
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/foo?preview", nil))
+	if w.Code != http.StatusMovedPermanently {
+		t.Errorf("preview disabled: got status %d, want %d", w.Code, http.StatusMovedPermanently)
+	}
+}
@@ -0,0 +1,136 @@
+// See LICENSE file for copyright and license details
+
+package shrt
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"djmo.ch/go-shrt/internal/qr"
+)
+
+const (
+	// DefaultQRSize is the width and height, in pixels, of QR
+	// codes for which no size is given.
+	DefaultQRSize = 256
+	// MaxQRSize is the largest QR code size accepted.
+	MaxQRSize = 4096
+	// MaxServedQRSize is the largest QR code size served by a
+	// ShrtHandler. Codes are rendered for each request, so anyone
+	// able to ask for large ones could make the server do much
+	// work for little effort.
+	MaxServedQRSize = 1024
+	// DefaultQRLevel is the error correction level of QR codes
+	// for which none is given.
+	DefaultQRLevel = "M"
+)
+
+// QROptions control the QR codes written by WriteQRCode.
+type QROptions struct {
+	// Size is the width and height of the image in pixels. Zero
+	// means DefaultQRSize. Codes that need more pixels than Size
+	// to give each module one pixel are made larger.
+	Size int
+	// Level is the error correction level: L, M, Q or H. Empty
+	// means DefaultQRLevel.
+	Level string
+	// Format is the image format: png or svg. Empty means png.
+	Format string
+}
+
+// ShortURL returns the canonical short URL of key,
+// https://SrvName/key.
+func (c Config) ShortURL(key string) string {
+	u := url.URL{Scheme: "https", Host: c.SrvName, Path: "/" + key}
+	return u.String()
+}
+
+// WriteQRCode writes a QR code encoding text to w, as described by
+// opts.
+func WriteQRCode(w io.Writer, text string, opts QROptions) error {
+	size := opts.Size
+	switch {
+	case size == 0:
+		size = DefaultQRSize
+	case size < 0 || size > MaxQRSize:
+		return fmt.Errorf("QR code size must be between 1 and %d: %d", MaxQRSize, size)
+	}
+	level := opts.Level
+	if level == "" {
+		level = DefaultQRLevel
+	}
+	l, err := qr.ParseLevel(level)
+	if err != nil {
+		return err
+	}
+	code, err := qr.Encode(text, l)
+	if err != nil {
+		return err
+	}
+	switch strings.ToLower(opts.Format) {
+	case "", "png":
+		return code.WritePNG(w, size)
+	case "svg":
+		return code.WriteSVG(w, size)
+	}
+	return fmt.Errorf("unknown QR code format: %q", opts.Format)
+}
+
+// qrKey reports whether req, whose path is the single element p,
+// requests a QR code, and returns the key of the entry to encode. A
+// path ending in ".qr" requests a QR code unless it is itself a key.
+func qrKey(c *compiled, req *http.Request, p string) (string, bool) {
+	if strings.HasSuffix(p, ".qr") {
+		if _, ok := c.lookup(p); !ok {
+			return strings.TrimSuffix(p, ".qr"), true
+		}
+	}
+	if strings.Contains(req.URL.RawQuery, "qr") {
+		q := req.URL.Query()
+		if _, ok := q["qr"]; ok && q.Get("go-get") != "1" {
+			return p, true
+		}
+	}
+	return "", false
+}
+
+// serveQR writes the QR code for the short URL of key, as described
+// by the size, ec and format query parameters of req.
+func serveQR(w http.ResponseWriter, req *http.Request, c *compiled, key string) {
+	if _, err := c.snap.Get(key); err != nil {
+		log.Println("not found:", key)
+		notFound(w, c.cfg.CacheNotFound)
+		return
+	}
+	q := req.URL.Query()
+	opts := QROptions{Level: q.Get("ec"), Format: q.Get("format")}
+	if s := q.Get("size"); s != "" {
+		size, err := strconv.Atoi(s)
+		if err != nil || size <= 0 || size > MaxServedQRSize {
+			http.Error(w, fmt.Sprintf("QR code size must be between 1 and %d", MaxServedQRSize), http.StatusBadRequest)
+			return
+		}
+		opts.Size = size
+	}
+	var buf bytes.Buffer
+	if err := WriteQRCode(&buf, c.cfg.ShortURL(key), opts); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	log.Println("QR code request for", key)
+	ctype := "image/png"
+	if strings.EqualFold(opts.Format, "svg") {
+		ctype = "image/svg+xml"
+	}
+	w.Header().Set("Content-Type", ctype)
+	w.Header().Set("Content-Length", strconv.Itoa(buf.Len()))
+	w.Header().Set("Etag", c.etag)
+	w.Header().Set("Last-Modified", c.lastModified)
+	w.Write(buf.Bytes())
+}
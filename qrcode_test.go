@@ -0,0 +1,92 @@
+// See LICENSE file for copyright and license details
+
+package shrt
+
+import (
+	"bytes"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"testing/fstest"
+)
+
+func TestQRCode(t *testing.T) {
+	db := "foo=shrtlnk:https://example.com/foo\n" +
+		"v1.qr=shrtlnk:https://example.com/v1\n" +
+		"bar=goget:https://example.com/bar\n"
+	h := &ShrtHandler{
+		ShrtFile: NewShrtFile(),
+		FS:       fstest.MapFS{"shrt.db": &fstest.MapFile{Data: []byte(db)}},
+		Config:   Config{SrvName: "example.org", ScmType: "git", DbPath: "shrt.db"},
+	}
+	if err := h.Reload(); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		path  string
+		code  int
+		ctype string
+	}{
+		{"/foo.qr", http.StatusOK, "image/png"},
+		{"/foo?qr", http.StatusOK, "image/png"},
+		{"/foo.qr?format=svg&size=100&ec=H", http.StatusOK, "image/svg+xml"},
+		{"/bar.qr", http.StatusOK, "image/png"},
+		{"/v1.qr", http.StatusMovedPermanently, ""},
+		{"/v1.qr.qr", http.StatusOK, "image/png"},
+		{"/bar?qr&go-get=1", http.StatusOK, "text/html; charset=utf-8"},
+		{"/baz.qr", http.StatusNotFound, ""},
+		{"/foo.qr?size=big", http.StatusBadRequest, ""},
+		{"/foo.qr?size=100000", http.StatusBadRequest, ""},
+		{"/foo.qr?size=1024", http.StatusOK, "image/png"},
+		{"/foo.qr?size=1025", http.StatusBadRequest, ""},
+		{"/foo.qr?ec=X", http.StatusBadRequest, ""},
+		{"/foo.qr?format=gif", http.StatusBadRequest, ""},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, tt.path, nil))
+		if w.Code != tt.code {
+			t.Errorf("%s: got status %d, want %d:\n%s", tt.path, w.Code, tt.code, w.Body)
+			continue
+		}
+		if tt.ctype != "" && w.Header().Get("Content-Type") != tt.ctype {
+			t.Errorf("%s: got Content-Type %q, want %q", tt.path, w.Header().Get("Content-Type"), tt.ctype)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/foo.qr", nil))
+	img, err := png.Decode(w.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b := img.Bounds(); b.Dx() != DefaultQRSize || b.Dy() != DefaultQRSize {
+		t.Errorf("got %v image, want %dx%[2]d", b, DefaultQRSize)
+	}
+
+	// The served code is the one written by WriteQRCode
+	var want bytes.Buffer
+	if err := WriteQRCode(&want, "https://example.org/foo", QROptions{Format: "svg", Size: 100, Level: "H"}); err != nil {
+		t.Fatal(err)
+	}
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/foo.qr?format=svg&size=100&ec=H", nil))
+	if w.Body.String() != want.String() {
+		t.Errorf("served SVG differs from WriteQRCode:\n%s", w.Body)
+	}
+}
+
+func TestShortURL(t *testing.T) {
+	cfg := Config{SrvName: "example.org"}
+	for key, want := range map[string]string{
+		"foo": "https://example.org/foo",
+		"c++": "https://example.org/c++",
+		"a b": "https://example.org/a%20b",
+	} {
+		if got := cfg.ShortURL(key); got != want {
+			t.Errorf("ShortURL(%q) = %q, want %q", key, got, want)
+		}
+	}
+}
@@ -0,0 +1,114 @@
+// See LICENSE file for copyright and license details
+
+package shrt
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+)
+
+// statusPath is the request path, less the leading slash, at which
+// ShrtHandler reports its [ReloadStatus].
+const statusPath = ".shrt/status"
+
+// ReloadStatus describes the outcome of the most recent call to
+// [ShrtHandler.Reload].
+type ReloadStatus struct {
+	// Time is when the reload was attempted. It is the zero time if
+	// Reload has never been called.
+	Time time.Time
+	// Err is the error that caused the reload to fail, or nil if it
+	// succeeded.
+	Err error
+	// Generation and Loaded describe the Snapshot being served
+	// after the reload. If the reload failed, this is the last
+	// good database.
+	Generation uint64
+	Loaded     time.Time
+	// Entries is the number of entries in the Snapshot being
+	// served.
+	Entries int
+}
+
+// Reload reads the database at the configured DbPath in FS into the
+// ShrtFile and, unless the handler is Lazy, pre-renders its
+// responses. If the database cannot be opened or contains errors,
+// the handler continues to serve the previous database and the error
+// is returned. The outcome is recorded and may be retrieved with
+// Status. Concurrent calls are serialized.
+func (s *ShrtHandler) Reload() error {
+	s.rmux.Lock()
+	defer s.rmux.Unlock()
+	err := s.reload()
+	snap := s.ShrtFile.Snapshot()
+	s.status.Store(&ReloadStatus{
+		Time:       time.Now(),
+		Err:        err,
+		Generation: snap.Generation,
+		Loaded:     snap.Loaded,
+		Entries:    snap.Len(),
+	})
+	return err
+}
+
+func (s *ShrtHandler) reload() error {
+	f, err := s.FS.Open(s.CurrentConfig().DbPath)
+	if err != nil {
+		return err
+	}
+	if err := s.ShrtFile.ReadShrtFile(f); err != nil {
+		return err
+	}
+	if !s.Lazy {
+		s.Prepare()
+	}
+	return nil
+}
+
+// Status returns the outcome of the most recent reload. If Reload
+// has never been called, Time is zero and the remaining fields
+// describe the ShrtFile's current Snapshot.
+func (s *ShrtHandler) Status() ReloadStatus {
+	if st, ok := s.status.Load().(*ReloadStatus); ok {
+		return *st
+	}
+	snap := s.ShrtFile.Snapshot()
+	return ReloadStatus{
+		Generation: snap.Generation,
+		Loaded:     snap.Loaded,
+		Entries:    snap.Len(),
+	}
+}
+
+func (s *ShrtHandler) serveStatus(w http.ResponseWriter) {
+	st := s.Status()
+	rsp := struct {
+		OK         bool       `json:"ok"`
+		Error      string     `json:"error,omitempty"`
+		LastReload *time.Time `json:"last_reload,omitempty"`
+		Generation uint64     `json:"generation"`
+		Loaded     time.Time  `json:"loaded"`
+		Entries    int        `json:"entries"`
+	}{
+		OK:         st.Err == nil,
+		Generation: st.Generation,
+		Loaded:     st.Loaded,
+		Entries:    st.Entries,
+	}
+	if st.Err != nil {
+		rsp.Error = st.Err.Error()
+	}
+	if !st.Time.IsZero() {
+		rsp.LastReload = &st.Time
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if !rsp.OK {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	if err := json.NewEncoder(w).Encode(rsp); err != nil {
+		log.Println("error encoding status:", err)
+	}
+}
@@ -12,19 +12,33 @@
 // an HTTP 200 response. If configured, requests to the base path
 // (i.e., "/") generate an HTTP 302 response.
 //
-// The database file is human-readable. See [Shrtfile] for the full
-// specification.
+// The database file is human-readable. See [ShrtFile] for the full
+// specification. The outcome of the most recent reload is reported
+// as JSON at /.shrt/status. If enabled by [Config.Preview], a preview
+// page describing the entry for a key is served at /key+. A QR code
+// encoding the short URL https://SrvName/key is served at /key.qr.
+// If [Config.CanonicalHost] is set, requests sent to hosts other
+// than SrvName are redirected to it or refused.
 package shrt
 
 import (
+	"errors"
 	"fmt"
-	"html/template"
 	"io/fs"
 	"log"
+	"net"
 	"net/http"
+	"net/url"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
+// allowedMethods is the value of the Allow header sent in response
+// to OPTIONS requests and disallowed methods.
+const allowedMethods = "GET, HEAD, OPTIONS"
+
 var robotstxt = `# Welcome to Shrt
 User-Agent: *
 Disallow:
@@ -60,9 +74,9 @@ type shrtRequest struct {
 	GoSourceFile string
 }
 
-// Config contains all of the global configuration for Shrt. All
-// values except BareRdr and DbPath are used in the go-import meta tag
-// values for go-get requests.
+// Config contains all of the global configuration for Shrt. SrvName,
+// ScmType, GoSourceDir and GoSourceFile are used in the go-import and
+// go-source meta tag values for go-get requests.
 type Config struct {
 	// Server name of the Shrt host
 	SrvName string
@@ -84,18 +98,140 @@ type Config struct {
 	// form the file entry in the go-source meta tag.  This
 	// key is experimental and may be removed in a future release.
 	GoSourceFile string
+	// The Cache-Control header values sent with shortlink
+	// redirects, go-get pages, redirects from the base path, and
+	// not found responses, respectively. No Cache-Control header
+	// is sent for a response class whose value is empty.
+	CacheShortLink string
+	CacheGoGet     string
+	CacheBareRdr   string
+	CacheNotFound  string
+	// Preview enables preview pages, which describe an entry
+	// instead of redirecting to it. The preview of an entry is
+	// requested by appending "+" to its key, as in /key+, or with
+	// the preview query parameter, as in /key?preview.
+	Preview bool
+	// CanonicalHost restricts the handler to requests sent to
+	// SrvName, so that go-get responses are only served under
+	// the name they give. Requests sent to one of AltHosts are
+	// redirected to the same path on SrvName, and requests sent
+	// to any other host are refused with 421 Misdirected Request.
+	// The status page is served regardless of host. AltHosts are
+	// host names or IP addresses, without ports; requests to any
+	// port of an alternate host are redirected.
+	CanonicalHost bool
+	AltHosts      []string
 }
 
 // ShrtHandler is the core [http.Handler] for go-shrt.
+//
+// Responses are pre-rendered for each database entry and swapped in
+// atomically whenever the ShrtFile is reloaded, so lookups do not
+// contend with each other or with reloads. The Config must not be
+// modified once the handler is in use; use SetConfig to change the
+// configuration of a running handler.
 type ShrtHandler struct {
 	ShrtFile *ShrtFile
 	Config   Config
 	FS       fs.FS
+	// Lazy disables pre-rendering, so that each response is
+	// rendered when it is requested. This suits processes that
+	// serve few requests before exiting, such as CGI programs,
+	// for which rendering every entry up front is wasted work.
+	Lazy bool
+
+	cfg      atomic.Value // *configState
+	compiled atomic.Value // *compiled
+	status   atomic.Value // *ReloadStatus
+	mux      sync.Mutex   // held while compiling
+	rmux     sync.Mutex   // serializes reloads
+}
+
+// Validate reports whether c is usable by a ShrtHandler.
+func (c Config) Validate() error {
+	switch {
+	case c.SrvName == "":
+		return errors.New("server name is empty")
+	case strings.ContainsAny(c.SrvName, "/:?# \t"):
+		return fmt.Errorf("server name is not a host name: %s", c.SrvName)
+	case c.DbPath == "":
+		return errors.New("database path is empty")
+	}
+	switch c.ScmType {
+	case "bzr", "fossil", "git", "hg", "mod", "svn":
+	default:
+		return fmt.Errorf("unknown SCM type: %s", c.ScmType)
+	}
+	if _, err := url.Parse(c.BareRdr); err != nil {
+		return fmt.Errorf("invalid base path redirect: %s", err)
+	}
+	for _, h := range c.AltHosts {
+		switch {
+		case h == "" || strings.ContainsAny(h, "/?# \t"):
+			return fmt.Errorf("alternate host is not a host name: %q", h)
+		case strings.Contains(h, ":") && net.ParseIP(strings.TrimSuffix(strings.TrimPrefix(h, "["), "]")) == nil:
+			return fmt.Errorf("alternate host has a port: %q", h)
+		}
+	}
+	for _, v := range []string{c.BareRdr, c.CacheShortLink, c.CacheGoGet, c.CacheBareRdr, c.CacheNotFound} {
+		if strings.ContainsAny(v, "\r\n") {
+			return fmt.Errorf("header value contains a line break: %q", v)
+		}
+	}
+	return nil
+}
+
+// configState is a Config in use by a ShrtHandler, along with the
+// time it was installed.
+type configState struct {
+	cfg Config
+	set time.Time
+}
+
+// SetConfig validates cfg and, if it is valid, atomically replaces
+// the configuration used by the handler. Requests already in
+// progress complete using the previous configuration. The Config
+// field is left unchanged.
+func (s *ShrtHandler) SetConfig(cfg Config) error {
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+	s.cfg.Store(&configState{cfg: cfg, set: time.Now()})
+	return nil
+}
+
+// CurrentConfig returns the configuration in use by the handler:
+// the Config most recently passed to SetConfig, or the Config field
+// if SetConfig has not been called.
+func (s *ShrtHandler) CurrentConfig() Config {
+	return s.config().cfg
+}
+
+func (s *ShrtHandler) config() *configState {
+	if cs, ok := s.cfg.Load().(*configState); ok {
+		return cs
+	}
+	s.cfg.CompareAndSwap(nil, &configState{cfg: s.Config})
+	return s.cfg.Load().(*configState)
 }
 
 // Handle implements the http.Handler interface.
+//
+// GET and HEAD requests are served as described in the package
+// documentation. OPTIONS requests receive an empty response listing
+// the allowed methods. Shortlink and go-get responses carry ETag and
+// Last-Modified headers derived from the database generation, and
+// go-get requests honor the If-None-Match and If-Modified-Since
+// headers.
 func (s *ShrtHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
-	if req.Method != http.MethodGet {
+	switch req.Method {
+	case http.MethodGet, http.MethodHead:
+	case http.MethodOptions:
+		w.Header().Set("Allow", allowedMethods)
+		w.WriteHeader(http.StatusNoContent)
+		return
+	default:
+		w.Header().Set("Allow", allowedMethods)
 		w.WriteHeader(http.StatusMethodNotAllowed)
 		fmt.Fprintf(w, "Method not allowed")
 		return
@@ -104,15 +240,28 @@ func (s *ShrtHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 	p := req.URL.Path
 	p = strings.TrimPrefix(p, "/")
 
+	if p == statusPath {
+		s.serveStatus(w)
+		return
+	}
+
+	c := s.responses()
+	cfg := c.cfg
+
+	if cfg.CanonicalHost && serveHost(w, req, cfg) {
+		return
+	}
+
 	if p == "robots.txt" {
 		log.Println("incoming robot")
 		fmt.Fprint(w, robotstxt)
 		return
 	}
 
-	if p == "" && s.Config.BareRdr != "" {
+	if p == "" && cfg.BareRdr != "" {
 		log.Println("shortlink request for /")
-		w.Header().Add("Location", s.Config.BareRdr)
+		setCacheControl(w, cfg.CacheBareRdr)
+		w.Header().Add("Location", cfg.BareRdr)
 		w.WriteHeader(http.StatusFound)
 		fmt.Fprintln(w, "Redirecting")
 		return
@@ -120,38 +269,91 @@ func (s *ShrtHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 
 	key := strings.SplitN(p, "/", 2)[0]
 
-	val, err := s.ShrtFile.Get(key)
-	if err != nil {
+	if key == p {
+		if k, ok := qrKey(c, req, key); ok {
+			serveQR(w, req, c, k)
+			return
+		}
+	}
+
+	if cfg.Preview && key == p {
+		if k, ok := previewKey(c, req, key); ok {
+			servePreview(w, c, k)
+			return
+		}
+	}
+
+	rsp, ok := c.lookup(key)
+	if !ok {
 		log.Println("not found:", key)
-		http.Error(w, "Not found", http.StatusNotFound)
+		notFound(w, cfg.CacheNotFound)
 		return
 	}
 
-	switch val.Type {
+	switch rsp.entry.Type {
 	case ShortLink:
 		if key != p {
 			log.Println("path elements following shortlink:", p)
-			http.Error(w, "Not found", http.StatusNotFound)
+			notFound(w, cfg.CacheNotFound)
 			return
 		}
 		log.Println("shortlink request for", key)
-		w.Header().Add("Location", val.URL)
+		copyHeader(w.Header(), rsp.header)
 		w.WriteHeader(http.StatusMovedPermanently)
-		fmt.Fprintln(w, "Redirecting")
+		w.Write(rsp.body)
 	case GoGet:
 		log.Println("go-get request for", key)
-		t := template.Must(template.New("shrt").Parse(shrtrsp))
-		sReq := shrtRequest{
-			SrvName:      s.Config.SrvName,
-			Repo:         key,
-			ScmType:      s.Config.ScmType,
-			URL:          val.URL,
-			DocPath:      p,
-			GoSourceDir:  s.Config.GoSourceDir,
-			GoSourceFile: s.Config.GoSourceFile,
+		copyHeader(w.Header(), rsp.header)
+		if notModified(req, c.etag, c.modtime) {
+			w.Header().Del("Content-Length")
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		if key == p && rsp.body != nil {
+			w.Write(rsp.body)
+			return
 		}
-		if err := t.Execute(w, sReq); err != nil {
+		w.Header().Del("Content-Length")
+		if err := shrtTmpl.Execute(w, goGetRequest(cfg, key, p, rsp.entry)); err != nil {
 			log.Println("error executing template:", err)
 		}
 	}
 }
+
+func notFound(w http.ResponseWriter, cacheControl string) {
+	setCacheControl(w, cacheControl)
+	http.Error(w, "Not found", http.StatusNotFound)
+}
+
+func setCacheControl(w http.ResponseWriter, value string) {
+	setHeader(w.Header(), "Cache-Control", value)
+}
+
+// copyHeader adds the pre-rendered header src to dst. The value
+// slices are shared, so neither header may be modified in place.
+func copyHeader(dst, src http.Header) {
+	for k, v := range src {
+		dst[k] = v
+	}
+}
+
+// notModified reports whether the conditional headers of req allow a
+// 304 response for a resource with the given validators. As required
+// by RFC 9110, If-Modified-Since is ignored when If-None-Match is
+// present.
+func notModified(req *http.Request, etag string, modtime time.Time) bool {
+	if inm := req.Header.Get("If-None-Match"); inm != "" {
+		for _, tag := range strings.Split(inm, ",") {
+			tag = strings.TrimSpace(tag)
+			if tag == "*" || strings.TrimPrefix(tag, "W/") == etag {
+				return true
+			}
+		}
+		return false
+	}
+	ims, err := http.ParseTime(req.Header.Get("If-Modified-Since"))
+	if err != nil {
+		return false
+	}
+	return !modtime.Truncate(time.Second).After(ims)
+}
@@ -0,0 +1,383 @@
+// See LICENSE file for copyright and license details
+
+package shrt
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"testing/fstest"
+)
+
+var testdb = `foo=shrtlnk:https://example.com/foo
+bar=goget:https://example.com/bar
+`
+
+func newTestHandler(t testing.TB) *ShrtHandler {
+	t.Helper()
+	fsys := fstest.MapFS{"shrt.db": &fstest.MapFile{Data: []byte(testdb)}}
+	f, err := fsys.Open("shrt.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	shrtfile := NewShrtFile()
+	if err := shrtfile.ReadShrtFile(f); err != nil {
+		t.Fatal(err)
+	}
+	return &ShrtHandler{
+		ShrtFile: shrtfile,
+		FS:       fsys,
+		Config: Config{
+			SrvName:       "example.org",
+			ScmType:       "git",
+			BareRdr:       "https://example.org/home",
+			DbPath:        "shrt.db",
+			CacheGoGet:    "max-age=60",
+			CacheNotFound: "no-store",
+		},
+	}
+}
+
+func TestMethods(t *testing.T) {
+	h := newTestHandler(t)
+	tests := []struct {
+		method string
+		path   string
+		code   int
+		allow  bool
+	}{
+		{http.MethodGet, "/foo", http.StatusMovedPermanently, false},
+		{http.MethodHead, "/foo", http.StatusMovedPermanently, false},
+		{http.MethodHead, "/bar", http.StatusOK, false},
+		{http.MethodOptions, "/foo", http.StatusNoContent, true},
+		{http.MethodOptions, "*", http.StatusNoContent, true},
+		{http.MethodPost, "/foo", http.StatusMethodNotAllowed, true},
+		{http.MethodDelete, "/bar", http.StatusMethodNotAllowed, true},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(tt.method, tt.path, nil))
+		if w.Code != tt.code {
+			t.Errorf("%s %s: got status %d, want %d", tt.method, tt.path, w.Code, tt.code)
+		}
+		if got := w.Header().Get("Allow"); tt.allow && got != allowedMethods {
+			t.Errorf("%s %s: got Allow %q, want %q", tt.method, tt.path, got, allowedMethods)
+		}
+	}
+}
+
+func TestConditionalGoGet(t *testing.T) {
+	h := newTestHandler(t)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/bar?go-get=1", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("got status %d, want %d", w.Code, http.StatusOK)
+	}
+	etag := w.Header().Get("ETag")
+	if etag == "" {
+		t.Fatal("no ETag header")
+	}
+	if got := w.Header().Get("Cache-Control"); got != "max-age=60" {
+		t.Errorf("got Cache-Control %q, want %q", got, "max-age=60")
+	}
+	if !strings.Contains(w.Body.String(), `name="go-import"`) {
+		t.Error("go-import meta tag missing from response")
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/bar?go-get=1", nil)
+	req.Header.Set("If-None-Match", `"nope", `+etag)
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if w.Code != http.StatusNotModified {
+		t.Errorf("got status %d, want %d", w.Code, http.StatusNotModified)
+	}
+	if w.Body.Len() != 0 {
+		t.Error("unexpected body in 304 response")
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/bar?go-get=1", nil)
+	req.Header.Set("If-Modified-Since", w.Header().Get("Last-Modified"))
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if w.Code != http.StatusNotModified {
+		t.Errorf("got status %d, want %d", w.Code, http.StatusNotModified)
+	}
+
+	f, _ := h.FS.Open(h.Config.DbPath)
+	if err := h.ShrtFile.ReadShrtFile(f); err != nil {
+		t.Fatal(err)
+	}
+	req = httptest.NewRequest(http.MethodGet, "/bar?go-get=1", nil)
+	req.Header.Set("If-None-Match", etag)
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Errorf("after reload: got status %d, want %d", w.Code, http.StatusOK)
+	}
+}
+
+func TestNotFoundCacheControl(t *testing.T) {
+	h := newTestHandler(t)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/nope", nil))
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("got status %d, want %d", w.Code, http.StatusNotFound)
+	}
+	if got := w.Header().Get("Cache-Control"); got != "no-store" {
+		t.Errorf("got Cache-Control %q, want %q", got, "no-store")
+	}
+}
+
+// newBenchHandler returns a handler serving a database of n entries,
+// alternating between shortlinks and go-get entries.
+func newBenchHandler(b *testing.B, n int) *ShrtHandler {
+	b.Helper()
+	log.SetOutput(io.Discard)
+	b.Cleanup(func() { log.SetOutput(os.Stderr) })
+	var db strings.Builder
+	for i := 0; i < n; i++ {
+		typ := "shrtlnk"
+		if i%2 == 1 {
+			typ = "goget"
+		}
+		fmt.Fprintf(&db, "key%d=%s:https://example.com/repo%d\n", i, typ, i)
+	}
+	fsys := fstest.MapFS{"shrt.db": &fstest.MapFile{Data: []byte(db.String())}}
+	h := &ShrtHandler{
+		ShrtFile: NewShrtFile(),
+		FS:       fsys,
+		Config:   Config{SrvName: "example.org", ScmType: "git", DbPath: "shrt.db"},
+	}
+	benchReload(b, h)
+	return h
+}
+
+func benchReload(b *testing.B, h *ShrtHandler) {
+	if err := reload(h); err != nil {
+		b.Fatal(err)
+	}
+}
+
+func reload(h *ShrtHandler) error {
+	f, err := h.FS.Open(h.Config.DbPath)
+	if err != nil {
+		return err
+	}
+	if err := h.ShrtFile.ReadShrtFile(f); err != nil {
+		return err
+	}
+	h.Prepare()
+	return nil
+}
+
+// discardWriter is a minimal http.ResponseWriter, so that benchmarks
+// measure the handler and not httptest.ResponseRecorder.
+type discardWriter http.Header
+
+func (w discardWriter) Header() http.Header         { return http.Header(w) }
+func (w discardWriter) Write(p []byte) (int, error) { return len(p), nil }
+func (w discardWriter) WriteHeader(int)             {}
+
+func benchRequests(b *testing.B, h *ShrtHandler, paths ...string) {
+	reqs := make([]*http.Request, len(paths))
+	for i, p := range paths {
+		reqs[i] = httptest.NewRequest(http.MethodGet, p, nil)
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			h.ServeHTTP(make(discardWriter), reqs[i%len(reqs)])
+			i++
+		}
+	})
+}
+
+func BenchmarkShortLink(b *testing.B) {
+	h := newBenchHandler(b, 1000)
+	benchRequests(b, h, "/key0", "/key2", "/key998")
+}
+
+func BenchmarkGoGet(b *testing.B) {
+	h := newBenchHandler(b, 1000)
+	benchRequests(b, h, "/key1?go-get=1", "/key3?go-get=1", "/key999?go-get=1")
+}
+
+func BenchmarkGoGetSubpath(b *testing.B) {
+	h := newBenchHandler(b, 1000)
+	benchRequests(b, h, "/key1/sub?go-get=1", "/key3/sub/pkg?go-get=1")
+}
+
+func BenchmarkNotFound(b *testing.B) {
+	h := newBenchHandler(b, 1000)
+	benchRequests(b, h, "/nope", "/key1000")
+}
+
+func BenchmarkReload(b *testing.B) {
+	h := newBenchHandler(b, 1000)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		benchReload(b, h)
+	}
+}
+
+// BenchmarkLookupDuringReload measures request throughput while the
+// database is continuously reloaded in the background.
+func BenchmarkLookupDuringReload(b *testing.B) {
+	h := newBenchHandler(b, 1000)
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-done:
+				return
+			default:
+				reload(h)
+			}
+		}
+	}()
+	benchRequests(b, h, "/key0", "/key1?go-get=1", "/nope")
+	b.StopTimer()
+	close(done)
+	wg.Wait()
+}
+
+func TestReloadStatus(t *testing.T) {
+	h := newTestHandler(t)
+	if err := h.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/.shrt/status", nil))
+	if w.Code != http.StatusOK {
+		t.Errorf("got status %d, want %d", w.Code, http.StatusOK)
+	}
+
+	h.FS.(fstest.MapFS)["shrt.db"] = &fstest.MapFile{Data: []byte("foo=bar\n")}
+	if err := h.Reload(); err == nil {
+		t.Fatal("expected error reloading invalid database")
+	}
+	st := h.Status()
+	if st.Err == nil || st.Generation != 2 || st.Entries != 2 {
+		t.Errorf("unexpected status after failed reload: %+v", st)
+	}
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/foo", nil))
+	if w.Code != http.StatusMovedPermanently {
+		t.Errorf("failed reload: got status %d, want %d", w.Code, http.StatusMovedPermanently)
+	}
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/.shrt/status", nil))
+	if w.Code != http.StatusServiceUnavailable || !strings.Contains(w.Body.String(), "line 1") {
+		t.Errorf("unexpected status response %d: %s", w.Code, w.Body)
+	}
+}
+
+func TestSetConfig(t *testing.T) {
+	h := newTestHandler(t)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/bar?go-get=1", nil))
+	etag := w.Header().Get("ETag")
+
+	cfg := h.CurrentConfig()
+	cfg.SrvName = ""
+	if err := h.SetConfig(cfg); err == nil {
+		t.Fatal("expected error for empty server name")
+	}
+	cfg.SrvName = "example.net"
+	if err := h.SetConfig(cfg); err != nil {
+		t.Fatal(err)
+	}
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/bar?go-get=1", nil))
+	if !strings.Contains(w.Body.String(), "example.net/bar") {
+		t.Error("new configuration not used")
+	}
+	if w.Header().Get("ETag") == etag {
+		t.Error("ETag unchanged by new configuration")
+	}
+	if h.Config.SrvName != "example.org" {
+		t.Error("Config field modified")
+	}
+}
+
+func TestLazy(t *testing.T) {
+	h := newTestHandler(t)
+	h.Lazy = true
+	if err := h.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	for path, code := range map[string]int{
+		"/foo":          http.StatusMovedPermanently,
+		"/bar?go-get=1": http.StatusOK,
+		"/baz":          http.StatusNotFound,
+	} {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		if w.Code != code {
+			t.Errorf("%s: got status %d, want %d", path, w.Code, code)
+		}
+	}
+	if c, _ := h.compiled.Load().(*compiled); c != nil {
+		t.Error("lazy handler pre-rendered its responses")
+	}
+}
+
+func TestCanonicalHost(t *testing.T) {
+	h := newTestHandler(t)
+	h.Config.CanonicalHost = true
+	h.Config.AltHosts = []string{"www.example.org", "192.0.2.1", "2001:db8::1"}
+	tests := []struct {
+		url      string
+		host     string
+		code     int
+		location string
+	}{
+		{"http://example.org/bar?go-get=1", "", http.StatusOK, ""},
+		{"http://example.org/foo", "EXAMPLE.org.:8080", http.StatusMovedPermanently, "https://example.com/foo"},
+		{"http://example.org/bar?go-get=1", "www.example.org", http.StatusMovedPermanently, "http://example.org/bar?go-get=1"},
+		{"https://example.org/foo", "www.example.org:8443", http.StatusMovedPermanently, "https://example.org:8443/foo"},
+		{"http://example.org/foo", "192.0.2.1", http.StatusMovedPermanently, "http://example.org/foo"},
+		{"http://example.org/foo", "[2001:db8::1]:80", http.StatusMovedPermanently, "http://example.org:80/foo"},
+		{"http://example.org/foo", "example.net", http.StatusMisdirectedRequest, ""},
+		{"http://example.org/robots.txt", "192.0.2.2", http.StatusMisdirectedRequest, ""},
+		{"http://example.org/" + statusPath, "192.0.2.2", http.StatusOK, ""},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, tt.url, nil)
+		if tt.host != "" {
+			req.Host = tt.host
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		if w.Code != tt.code {
+			t.Errorf("%s (Host %s): got status %d, want %d", tt.url, tt.host, w.Code, tt.code)
+		}
+		if got := w.Header().Get("Location"); got != tt.location {
+			t.Errorf("%s (Host %s): got Location %q, want %q", tt.url, tt.host, got, tt.location)
+		}
+	}
+
+	cfg := h.CurrentConfig()
+	for _, alt := range []string{"example.net/", "example.net:8080", "[2001:db8::1]:80", "192.0.2.1:80"} {
+		cfg.AltHosts = []string{alt}
+		if err := h.SetConfig(cfg); err == nil {
+			t.Errorf("alternate host %q: no error", alt)
+		}
+	}
+	cfg.AltHosts = []string{"[2001:db8::2]", "2001:db8::3"}
+	if err := h.SetConfig(cfg); err != nil {
+		t.Errorf("IPv6 alternate hosts: %v", err)
+	}
+}
@@ -5,9 +5,14 @@ package shrt
 import (
 	"bufio"
 	"fmt"
+	"io"
 	"io/fs"
+	"net/url"
+	"sort"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
 // ShrtType is the type of a ShrtFile entry. Their textual
@@ -20,6 +25,50 @@ const (
 	GoGet              // goget
 )
 
+// String returns the textual representation of t used in a
+// ShrtFile.
+func (t ShrtType) String() string {
+	switch t {
+	case ShortLink:
+		return "shrtlnk"
+	case GoGet:
+		return "goget"
+	default:
+		return "none"
+	}
+}
+
+// ParseShrtType returns the ShrtType whose textual representation is
+// s.
+func ParseShrtType(s string) (ShrtType, error) {
+	switch s {
+	case "shrtlnk":
+		return ShortLink, nil
+	case "goget":
+		return GoGet, nil
+	default:
+		return NoneType, fmt.Errorf("unrecognized type: %s", s)
+	}
+}
+
+// MarshalText implements the encoding.TextMarshaler interface.
+func (t ShrtType) MarshalText() ([]byte, error) {
+	if t != ShortLink && t != GoGet {
+		return nil, fmt.Errorf("invalid ShrtType: %d", int(t))
+	}
+	return []byte(t.String()), nil
+}
+
+// UnmarshalText implements the encoding.TextUnmarshaler interface.
+func (t *ShrtType) UnmarshalText(text []byte) error {
+	typ, err := ParseShrtType(string(text))
+	if err != nil {
+		return err
+	}
+	*t = typ
+	return nil
+}
+
 // ShrtEntry is a ShrtFile entry.
 type ShrtEntry struct {
 	URL  string
@@ -33,66 +82,226 @@ type ShrtEntry struct {
 // right. The value is then split around the first occurence of the
 // colon character, with the left side representing the type, and the
 // right side representing the URL. Whitespace is trimmed from the
-// beginning and end of all fields.
+// beginning and end of all fields. Blank lines, and lines whose first
+// non-blank character is '#', are ignored, except that the comment
+// lines directly above an entry are taken as its description.
+// Annotations, which are comment lines whose text begins with a tag
+// of lower case letters, digits and hyphens followed by a colon, as in
+// "# probe: dead as of 2006-01-02", are notes for maintenance tools
+// and are left out of the description. See [Editor.Annotate].
+//
+// Every read of a ShrtFile produces a new [Snapshot]. The file is
+// parsed and validated in full before the Snapshot is published, so a
+// failed read leaves the previous Snapshot in place.
 //
 // ShrtFile is safe for concurrent use across multiple goroutines.
+// Lookups never block, even while the file is being read.
 type ShrtFile struct {
-	m   map[string]ShrtEntry
-	mux sync.RWMutex
+	snap atomic.Value // *Snapshot
+	gen  uint64       // guarded by mux
+	mux  sync.Mutex   // serializes reads
+}
+
+// A Snapshot is an immutable view of the contents of a ShrtFile at a
+// point in time.
+type Snapshot struct {
+	// Generation is incremented each time a ShrtFile is read
+	// successfully. The empty database has generation zero.
+	Generation uint64
+	// Loaded is the time the Snapshot was published.
+	Loaded time.Time
+
+	m    map[string]ShrtEntry
+	desc map[string]string
 }
 
 // The NewShrtFile function returns a new ShrtFile.
 func NewShrtFile() *ShrtFile {
-	return &ShrtFile{m: make(map[string]ShrtEntry)}
+	s := new(ShrtFile)
+	s.snap.Store(emptySnapshot)
+	return s
 }
 
+var emptySnapshot = &Snapshot{m: make(map[string]ShrtEntry)}
+
 // The ReadShrtFile function reads an existing ShrtFile from f and
-// returns a pointer to a ShrtFile object. The provided file is closed
-// before returning.
+// publishes its contents as a new Snapshot. If f cannot be read or
+// contains errors, the current Snapshot is kept and an error is
+// returned. Syntax errors are reported together as an [ErrorList].
+// The provided file is closed before returning.
 func (s *ShrtFile) ReadShrtFile(f fs.File) error {
 	defer f.Close()
+
+	m, desc, err := parseShrtFile(f)
+	if err != nil {
+		return err
+	}
+
 	s.mux.Lock()
 	defer s.mux.Unlock()
+	s.gen++
+	s.snap.Store(&Snapshot{
+		Generation: s.gen,
+		Loaded:     time.Now(),
+		m:          m,
+		desc:       desc,
+	})
+	return nil
+}
+
+// A SyntaxError describes a problem with a single line of a
+// ShrtFile.
+type SyntaxError struct {
+	Line int    // 1-based line number
+	Msg  string // description of the problem
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("line %d: %s", e.Line, e.Msg)
+}
+
+// An ErrorList is the list of every SyntaxError found while reading
+// a ShrtFile, in line order.
+type ErrorList []*SyntaxError
 
-	s.m = make(map[string]ShrtEntry)
+// Error returns the errors in the list, one per line.
+func (l ErrorList) Error() string {
+	msgs := make([]string, len(l))
+	for i, e := range l {
+		msgs[i] = e.Error()
+	}
+	return strings.Join(msgs, "\n")
+}
 
-	scnr := bufio.NewScanner(f)
+// parseShrtFile parses and validates the entries read from r, and
+// returns them along with their descriptions. If any line is invalid,
+// parsing continues to the end of the input and an ErrorList
+// describing every invalid line is returned.
+func parseShrtFile(r io.Reader) (map[string]ShrtEntry, map[string]string, error) {
+	var (
+		m       = make(map[string]ShrtEntry)
+		desc    = make(map[string]string)
+		comment []string
+		errs    ErrorList
+		line    int
+	)
+	scnr := bufio.NewScanner(r)
 
 	for scnr.Scan() {
-		tok := strings.SplitN(scnr.Text(), "=", 2)
-		if _, ok := s.m[strings.Trim(tok[0], " ")]; ok {
-			return fmt.Errorf("repeat key: %s", tok[0])
+		line++
+		if isComment(scnr.Text()) {
+			text := strings.TrimSpace(scnr.Text())
+			if text == "" {
+				comment = comment[:0]
+			} else if text = strings.TrimSpace(text[1:]); text != "" && !isAnnotation(text) {
+				comment = append(comment, text)
+			}
+			continue
 		}
-		if len(tok) != 2 {
-			return fmt.Errorf("invalid syntax: %s", scnr.Text())
+		if len(comment) > 0 {
+			desc[strings.TrimSpace(strings.SplitN(scnr.Text(), "=", 2)[0])] = strings.Join(comment, " ")
+			comment = comment[:0]
 		}
-		key := strings.TrimSpace(tok[0])
-		tok = strings.SplitN(tok[1], ":", 2)
-		if len(tok) != 2 {
-			return fmt.Errorf("invalid syntax: %s", scnr.Text())
+		key, val, err := parseLine(scnr.Text())
+		if err == nil {
+			if _, ok := m[key]; ok {
+				err = fmt.Errorf("repeat key: %s", key)
+			}
 		}
-		var typ ShrtType
-		switch strings.TrimSpace(tok[0]) {
-		case "shrtlnk":
-			typ = ShortLink
-		case "goget":
-			typ = GoGet
-		default:
-			return fmt.Errorf("unrecognized type: %s", tok[0])
+		if err != nil {
+			errs = append(errs, &SyntaxError{Line: line, Msg: err.Error()})
+			continue
 		}
-		s.m[key] = ShrtEntry{
-			Type: typ,
-			URL:  strings.TrimSpace(tok[1]),
+		m[key] = val
+	}
+	if err := scnr.Err(); err != nil {
+		return nil, nil, err
+	}
+	if len(errs) > 0 {
+		return nil, nil, errs
+	}
+	return m, desc, nil
+}
+
+// isComment reports whether a ShrtFile line is blank or a comment.
+func isComment(text string) bool {
+	text = strings.TrimSpace(text)
+	return text == "" || text[0] == '#'
+}
+
+// isAnnotation reports whether the text of a comment line is an
+// annotation: a tag of lower case letters, digits and hyphens followed
+// by a colon.
+func isAnnotation(text string) bool {
+	word := strings.Fields(text)[0]
+	tag := strings.TrimSuffix(word, ":")
+	if tag == word || tag == "" {
+		return false
+	}
+	for _, r := range tag {
+		if (r < 'a' || r > 'z') && (r < '0' || r > '9') && r != '-' {
+			return false
 		}
 	}
-	return nil
+	return true
+}
+
+// parseLine parses a single ShrtFile line into its key and entry.
+func parseLine(text string) (string, ShrtEntry, error) {
+	var entry ShrtEntry
+	tok := strings.SplitN(text, "=", 2)
+	if len(tok) != 2 {
+		return "", entry, fmt.Errorf("invalid syntax: %s", text)
+	}
+	key := strings.TrimSpace(tok[0])
+	if strings.Contains(key, "/") {
+		return "", entry, fmt.Errorf("key contains '/': %s", key)
+	}
+	tok = strings.SplitN(tok[1], ":", 2)
+	if len(tok) != 2 {
+		return "", entry, fmt.Errorf("invalid syntax: %s", text)
+	}
+	typ, err := ParseShrtType(strings.TrimSpace(tok[0]))
+	if err != nil {
+		return "", entry, err
+	}
+	entry.Type = typ
+	entry.URL = strings.TrimSpace(tok[1])
+	if entry.URL == "" {
+		return "", entry, fmt.Errorf("missing URL: %s", text)
+	}
+	if _, err := url.Parse(entry.URL); err != nil {
+		return "", entry, fmt.Errorf("invalid URL: %s", err)
+	}
+	return key, entry, nil
+}
+
+// The Snapshot method returns the current Snapshot. The result is
+// never nil.
+func (s *ShrtFile) Snapshot() *Snapshot {
+	if snap, ok := s.snap.Load().(*Snapshot); ok {
+		return snap
+	}
+	return emptySnapshot
+}
+
+// The Get method gets the value of the specified key from the
+// current Snapshot. If the key does not exist, an error is returned.
+func (s *ShrtFile) Get(key string) (ShrtEntry, error) {
+	return s.Snapshot().Get(key)
+}
+
+// The Generation method returns the generation and load time of the
+// current Snapshot. Together they identify a particular version of
+// the database.
+func (s *ShrtFile) Generation() (uint64, time.Time) {
+	snap := s.Snapshot()
+	return snap.Generation, snap.Loaded
 }
 
 // The Get method gets the value of the specified key. If the key
 // does not exist, an error is returned.
-func (s *ShrtFile) Get(key string) (ShrtEntry, error) {
-	s.mux.RLock()
-	defer s.mux.RUnlock()
+func (s *Snapshot) Get(key string) (ShrtEntry, error) {
 	entry, ok := s.m[key]
 	if !ok {
 		entry.Type = NoneType
@@ -100,3 +309,26 @@ func (s *ShrtFile) Get(key string) (ShrtEntry, error) {
 	}
 	return entry, nil
 }
+
+// The Description method returns the description of the entry for
+// key: the text of the comment lines directly above it, other than
+// annotations, joined by spaces. If the entry has no description, or does not exist, the
+// empty string is returned.
+func (s *Snapshot) Description(key string) string {
+	return s.desc[key]
+}
+
+// The Len method returns the number of entries in the Snapshot.
+func (s *Snapshot) Len() int {
+	return len(s.m)
+}
+
+// The Keys method returns the keys in the Snapshot in sorted order.
+func (s *Snapshot) Keys() []string {
+	keys := make([]string, 0, len(s.m))
+	for k := range s.m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,92 @@
+// See LICENSE file for copyright and license details
+
+package shrt
+
+import (
+	"testing"
+	"testing/fstest"
+)
+
+func TestReadShrtFile(t *testing.T) {
+	fsys := fstest.MapFS{
+		"good.db": &fstest.MapFile{Data: []byte(testdb)},
+		"bad.db":  &fstest.MapFile{Data: []byte("baz=shrtlnk:https://example.com/baz\nqux\n")},
+	}
+	s := NewShrtFile()
+	if snap := s.Snapshot(); snap.Generation != 0 || snap.Len() != 0 {
+		t.Fatalf("new ShrtFile not empty: generation %d, %d entries", snap.Generation, snap.Len())
+	}
+
+	f, _ := fsys.Open("good.db")
+	if err := s.ReadShrtFile(f); err != nil {
+		t.Fatal(err)
+	}
+	good := s.Snapshot()
+	if good.Generation != 1 {
+		t.Errorf("got generation %d, want 1", good.Generation)
+	}
+	if keys := good.Keys(); len(keys) != 2 || keys[0] != "bar" || keys[1] != "foo" {
+		t.Errorf("unexpected keys: %v", keys)
+	}
+	if e, err := s.Get("bar"); err != nil || e.Type != GoGet || e.URL != "https://example.com/bar" {
+		t.Errorf("unexpected entry for bar: %+v, %v", e, err)
+	}
+
+	f, _ = fsys.Open("bad.db")
+	if err := s.ReadShrtFile(f); err == nil {
+		t.Fatal("expected error reading bad.db")
+	}
+	if s.Snapshot() != good {
+		t.Error("failed read replaced the current snapshot")
+	}
+	if _, err := s.Get("baz"); err == nil {
+		t.Error("failed read left a partial entry behind")
+	}
+}
+
+func TestZeroShrtFile(t *testing.T) {
+	var s ShrtFile
+	if _, err := s.Get("foo"); err == nil {
+		t.Error("expected error from zero ShrtFile")
+	}
+}
+
+func TestReadShrtFileErrors(t *testing.T) {
+	db := "foo=shrtlnk:https://example.com/foo\n" +
+		"bar\n" +
+		"baz=nope:https://example.com/baz\n" +
+		"foo=goget:https://example.com/foo\n"
+	fsys := fstest.MapFS{"shrt.db": &fstest.MapFile{Data: []byte(db)}}
+	f, _ := fsys.Open("shrt.db")
+	err := NewShrtFile().ReadShrtFile(f)
+	list, ok := err.(ErrorList)
+	if !ok {
+		t.Fatalf("got %T, want ErrorList", err)
+	}
+	want := []int{2, 3, 4}
+	if len(list) != len(want) {
+		t.Fatalf("got %d errors, want %d: %v", len(list), len(want), list)
+	}
+	for i, e := range list {
+		if e.Line != want[i] {
+			t.Errorf("error %d: got line %d, want %d", i, e.Line, want[i])
+		}
+	}
+}
+
+func TestIsAnnotation(t *testing.T) {
+	tests := map[string]bool{
+		"probe: dead as of 2026-01-02": true,
+		"link-check2: ok":              true,
+		"probe:":                       true,
+		"Note: the old home page":      false,
+		"see https://example.com/":     false,
+		": nothing":                    false,
+		"The foo home page":            false,
+	}
+	for text, want := range tests {
+		if got := isAnnotation(text); got != want {
+			t.Errorf("isAnnotation(%q) = %v, want %v", text, got, want)
+		}
+	}
+}
@@ -0,0 +1,365 @@
+// See LICENSE file for copyright and license details
+
+package shrt
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+var uiTmpl = template.Must(template.New("ui").Funcs(template.FuncMap{
+	"pathEscape": url.PathEscape,
+}).Parse(`{{ define "head" }}<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{ .Title }} - Shrt</title>
+<style>
+body { font-family: sans-serif; margin: 2em auto; max-width: 60em; padding: 0 1em; }
+table { border-collapse: collapse; width: 100%; }
+th, td { border-bottom: 1px solid #ccc; padding: .3em; text-align: left; }
+td.url { word-break: break-all; }
+.errors { border: 1px solid #c00; color: #c00; padding: .5em; }
+.message { border: 1px solid #080; color: #080; padding: .5em; }
+</style>
+</head>
+<body>
+<h1><a href="{{ .Prefix }}/">Shrt</a></h1>
+{{ if .Message }}<p class="message">{{ .Message }}</p>{{ end }}
+{{ if .Errors }}<div class="errors"><ul>{{ range .Errors }}<li>{{ . }}</li>{{ end }}</ul></div>{{ end }}
+{{ end }}
+
+{{ define "error" }}{{ template "head" . }}
+</body>
+</html>
+{{ end }}
+
+{{ define "form" }}<input type="hidden" name="csrf" value="{{ .CSRF }}">
+<label>Type <select name="type">
+<option value="shrtlnk"{{ if eq .Form.Type.String "shrtlnk" }} selected{{ end }}>shortlink</option>
+<option value="goget"{{ if eq .Form.Type.String "goget" }} selected{{ end }}>go-get</option>
+</select></label>
+<label>URL <input type="url" name="url" size="50" value="{{ .Form.URL }}" required></label>
+{{ end }}
+
+{{ define "list" }}{{ template "head" . }}
+<form method="get" action="{{ .Prefix }}/">
+<input type="search" name="q" value="{{ .Query }}" placeholder="Search">
+<button type="submit">Search</button>
+</form>
+<table>
+<tr><th>Key</th><th>Type</th><th>Target</th><th></th></tr>
+{{ range .Entries }}<tr>
+<td>{{ .Key }}</td><td>{{ .Type }}</td><td class="url">{{ .URL }}</td>
+<td><a href="{{ $.Prefix }}/edit/{{ pathEscape .Key }}">edit</a></td>
+</tr>
+{{ else }}<tr><td colspan="4">No entries{{ if .Query }} match {{ .Query }}{{ end }}.</td></tr>
+{{ end }}</table>
+<p>{{ len .Entries }} of {{ .Total }} entries.</p>
+<h2>Add a link</h2>
+<form method="post" action="{{ .Prefix }}/">
+<label>Key <input type="text" name="key" value="{{ .Form.Key }}" placeholder="generated if empty"></label>
+{{ template "form" . }}
+<button type="submit">Add</button>
+</form>
+</body>
+</html>
+{{ end }}
+
+{{ define "edit" }}{{ template "head" . }}
+<h2>Edit {{ .Form.Key }}</h2>
+<form method="post" action="{{ .Prefix }}/edit/{{ pathEscape .Form.Key }}">
+{{ template "form" . }}
+<button type="submit" name="action" value="save">Save</button>
+<button type="submit" name="action" value="delete">Delete</button>
+</form>
+</body>
+</html>
+{{ end }}`))
+
+// UIHandler is an [http.Handler] implementing a web interface for
+// browsing and editing the entries of a database file. The interface
+// is rendered on the server and requires no JavaScript. Every request
+// must be authenticated by Credentials; browsers are asked for a user
+// name and password with HTTP basic authentication.
+//
+// The interface consists of the following pages, relative to Prefix:
+//
+//	GET  /           list the entries, filtered by the query parameter q
+//	POST /           create an entry, generating its key if none is given
+//	GET  /edit/KEY   show the entry for KEY
+//	POST /edit/KEY   replace or delete the entry for KEY
+//
+// Forms are protected from cross-site request forgery by a token
+// derived from Secret and the name of the authenticated user.
+//
+// Like [AdminHandler], entries are read from the database being served
+// by Handler, and changes are made to the file at Path with
+// [EditShrtFile] and followed by a reload.
+type UIHandler struct {
+	// Handler is the ShrtHandler serving the database.
+	Handler *ShrtHandler
+	// Path is the operating system path of the database file.
+	Path string
+	// Prefix is removed from request paths before routing, and is
+	// added to the links and forms in each page.
+	Prefix string
+	// Credentials authenticate requests. If nil, every request is
+	// refused.
+	Credentials *Credentials
+	// Reload, if not nil, is called to reload the database after a
+	// change. Otherwise, Handler.Reload is used.
+	Reload func() error
+	// Keys generates the keys of entries added without one. If
+	// nil, the zero KeyGenerator is used.
+	Keys *KeyGenerator
+	// Secret is the key used to sign CSRF tokens. If empty, a random
+	// key is generated when the handler is first used, and tokens
+	// issued by other handlers, including those of earlier runs of
+	// the program, are refused.
+	Secret []byte
+
+	once   sync.Once
+	secret []byte
+}
+
+type uiPage struct {
+	Title   string
+	Prefix  string
+	CSRF    string
+	Message string
+	Errors  []string
+	Query   string
+	Entries []adminEntry
+	Total   int
+	Form    adminEntry
+}
+
+// uiMessages are the confirmations shown after a successful change,
+// indexed by the value of the "done" query parameter.
+var uiMessages = map[string]string{
+	"created": "Created ",
+	"updated": "Updated ",
+	"deleted": "Deleted ",
+}
+
+// ServeHTTP implements the http.Handler interface.
+func (u *UIHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Content-Security-Policy",
+		"default-src 'none'; style-src 'unsafe-inline'; form-action 'self'; frame-ancestors 'none'")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+
+	user, ok := u.Credentials.Authenticate(req)
+	if !ok {
+		w.Header().Set("WWW-Authenticate", `Basic realm="shrt", charset="UTF-8"`)
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	page := &uiPage{Prefix: u.Prefix, CSRF: u.csrfToken(user)}
+
+	p := strings.TrimPrefix(req.URL.Path, u.Prefix)
+	switch {
+	case p == "" || p == "/":
+		switch req.Method {
+		case http.MethodGet, http.MethodHead:
+			if msg, ok := uiMessages[req.FormValue("done")]; ok {
+				page.Message = msg + req.FormValue("key") + "."
+			}
+			u.list(w, req, page, http.StatusOK)
+		case http.MethodPost:
+			if u.checkCSRF(w, req, user) {
+				u.create(w, req, page, user)
+			}
+		default:
+			w.Header().Set("Allow", "GET, HEAD, POST")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	case strings.HasPrefix(p, "/edit/"):
+		key := strings.TrimPrefix(p, "/edit/")
+		switch req.Method {
+		case http.MethodGet, http.MethodHead:
+			u.edit(w, page, key)
+		case http.MethodPost:
+			if u.checkCSRF(w, req, user) {
+				u.update(w, req, page, user, key)
+			}
+		default:
+			w.Header().Set("Allow", "GET, HEAD, POST")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	default:
+		http.NotFound(w, req)
+	}
+}
+
+func (u *UIHandler) list(w http.ResponseWriter, req *http.Request, page *uiPage, status int) {
+	page.Title = "Links"
+	page.Query = strings.TrimSpace(req.FormValue("q"))
+	q := strings.ToLower(page.Query)
+	snap := u.Handler.ShrtFile.Snapshot()
+	page.Total = snap.Len()
+	for _, key := range snap.Keys() {
+		val, _ := snap.Get(key)
+		if q != "" && !strings.Contains(strings.ToLower(key), q) &&
+			!strings.Contains(strings.ToLower(val.URL), q) {
+			continue
+		}
+		page.Entries = append(page.Entries, adminEntry{Key: key, Type: val.Type, URL: val.URL})
+	}
+	if page.Form.Type == NoneType {
+		page.Form.Type = ShortLink
+	}
+	renderPage(w, "list", page, status)
+}
+
+func (u *UIHandler) edit(w http.ResponseWriter, page *uiPage, key string) {
+	val, err := u.Handler.ShrtFile.Get(key)
+	if err != nil {
+		page.Title = "Not found"
+		page.Errors = []string{err.Error()}
+		renderPage(w, "error", page, http.StatusNotFound)
+		return
+	}
+	page.Title = key
+	page.Form = adminEntry{Key: key, Type: val.Type, URL: val.URL}
+	renderPage(w, "edit", page, http.StatusOK)
+}
+
+func (u *UIHandler) create(w http.ResponseWriter, req *http.Request, page *uiPage, user string) {
+	page.Form = adminEntry{
+		Key: strings.TrimSpace(req.PostFormValue("key")),
+		URL: strings.TrimSpace(req.PostFormValue("url")),
+	}
+	typ, err := ParseShrtType(req.PostFormValue("type"))
+	if err == nil {
+		page.Form.Type = typ
+		err = EditShrtFile(u.Path, func(ed *Editor) error {
+			e := page.Form
+			if err := createEntry(ed, u.Keys, &e); err != nil {
+				return err
+			}
+			page.Form = e
+			return nil
+		})
+	}
+	if err != nil {
+		page.Errors = errorStrings(err)
+		u.list(w, req, page, http.StatusUnprocessableEntity)
+		return
+	}
+	log.Printf("ui: %s created %s", user, FormatEntry(page.Form.Key, ShrtEntry{Type: page.Form.Type, URL: page.Form.URL}))
+	u.done(w, req, "created", page.Form.Key)
+}
+
+func (u *UIHandler) update(w http.ResponseWriter, req *http.Request, page *uiPage, user, key string) {
+	page.Title = key
+	page.Form = adminEntry{Key: key, URL: strings.TrimSpace(req.PostFormValue("url"))}
+	if req.PostFormValue("action") == "delete" {
+		err := EditShrtFile(u.Path, func(ed *Editor) error {
+			if !ed.Delete(key) {
+				return errNotExist
+			}
+			return nil
+		})
+		if err != nil {
+			page.Errors = errorStrings(err)
+			page.Form.Type, _ = ParseShrtType(req.PostFormValue("type"))
+			renderPage(w, "edit", page, http.StatusUnprocessableEntity)
+			return
+		}
+		log.Printf("ui: %s deleted %s", user, key)
+		u.done(w, req, "deleted", key)
+		return
+	}
+
+	typ, err := ParseShrtType(req.PostFormValue("type"))
+	if err == nil {
+		page.Form.Type = typ
+		err = EditShrtFile(u.Path, func(ed *Editor) error {
+			if _, ok := ed.Get(key); !ok {
+				return errNotExist
+			}
+			return ed.Set(key, ShrtEntry{Type: typ, URL: page.Form.URL})
+		})
+	}
+	if err != nil {
+		page.Errors = errorStrings(err)
+		renderPage(w, "edit", page, http.StatusUnprocessableEntity)
+		return
+	}
+	log.Printf("ui: %s set %s", user, FormatEntry(key, ShrtEntry{Type: typ, URL: page.Form.URL}))
+	u.done(w, req, "updated", key)
+}
+
+// done reloads the database after a successful change and redirects
+// to the list of entries, which confirms the change.
+func (u *UIHandler) done(w http.ResponseWriter, req *http.Request, what, key string) {
+	if err := reloadWith(u.Handler, u.Reload); err != nil {
+		page := &uiPage{Title: "Reload failed", Prefix: u.Prefix, Errors: errorStrings(err)}
+		page.Errors[0] = "The change was saved, but the reload failed: " + page.Errors[0]
+		renderPage(w, "error", page, http.StatusInternalServerError)
+		return
+	}
+	v := url.Values{"done": {what}, "key": {key}}
+	http.Redirect(w, req, u.Prefix+"/?"+v.Encode(), http.StatusSeeOther)
+}
+
+// csrfToken returns the CSRF token for user.
+func (u *UIHandler) csrfToken(user string) string {
+	u.once.Do(func() {
+		u.secret = u.Secret
+		if len(u.secret) == 0 {
+			u.secret = make([]byte, 32)
+			if _, err := rand.Read(u.secret); err != nil {
+				panic(err)
+			}
+		}
+	})
+	mac := hmac.New(sha256.New, u.secret)
+	mac.Write([]byte("shrt-ui\x00" + user))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// checkCSRF reports whether the form posted in req carries a valid
+// CSRF token for user and, if not, refuses the request.
+func (u *UIHandler) checkCSRF(w http.ResponseWriter, req *http.Request, user string) bool {
+	token := req.PostFormValue("csrf")
+	if !hmac.Equal([]byte(token), []byte(u.csrfToken(user))) {
+		http.Error(w, "invalid or missing CSRF token", http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+// errorStrings returns the messages for err, one for each error in an
+// ErrorList.
+func errorStrings(err error) []string {
+	var list ErrorList
+	if !errors.As(err, &list) {
+		return []string{err.Error()}
+	}
+	msgs := make([]string, len(list))
+	for i, e := range list {
+		msgs[i] = e.Error()
+	}
+	return msgs
+}
+
+func renderPage(w http.ResponseWriter, name string, page *uiPage, status int) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	if err := uiTmpl.ExecuteTemplate(w, name, page); err != nil {
+		log.Println("ui: error rendering page:", err)
+	}
+}
@@ -0,0 +1,91 @@
+// See LICENSE file for copyright and license details
+
+package shrt
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+)
+
+func newTestUI(t *testing.T, db string) *UIHandler {
+	t.Helper()
+	a, path := newTestAdmin(t, db)
+	return &UIHandler{
+		Handler:     a.Handler,
+		Path:        path,
+		Prefix:      "/.shrt/ui",
+		Credentials: &Credentials{Users: map[string]string{"alice": "{SHA}5en6G6MezRroT3XKqkdPOmY/BfQ="}},
+		Secret:      []byte("test"),
+	}
+}
+
+func uiRequest(u *UIHandler, method, path string, form url.Values) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(form.Encode()))
+	if form != nil {
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+	req.SetBasicAuth("alice", "secret")
+	w := httptest.NewRecorder()
+	u.ServeHTTP(w, req)
+	return w
+}
+
+func TestUIHandler(t *testing.T) {
+	u := newTestUI(t, "foo=shrtlnk:https://example.com/foo\nbar=goget:https://example.com/bar\n")
+	csrf := u.csrfToken("alice")
+
+	req := httptest.NewRequest(http.MethodGet, "/.shrt/ui/", nil)
+	w := httptest.NewRecorder()
+	u.ServeHTTP(w, req)
+	if w.Code != http.StatusUnauthorized || w.Header().Get("WWW-Authenticate") == "" {
+		t.Errorf("unauthenticated: got status %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+
+	w = uiRequest(u, http.MethodGet, "/.shrt/ui/?q=BAR", nil)
+	body := w.Body.String()
+	if w.Code != http.StatusOK || !strings.Contains(body, `href="/.shrt/ui/edit/bar"`) ||
+		strings.Contains(body, "/edit/foo") {
+		t.Errorf("search: got status %d and body:\n%s", w.Code, body)
+	}
+
+	tests := []struct {
+		path     string
+		form     url.Values
+		code     int
+		contains string
+	}{
+		{"/.shrt/ui/", url.Values{"key": {"baz"}, "type": {"shrtlnk"}, "url": {"https://example.com/baz"}}, http.StatusForbidden, "CSRF"},
+		{"/.shrt/ui/", url.Values{"csrf": {csrf}, "key": {"baz"}, "type": {"shrtlnk"}, "url": {"https://example.com/baz"}}, http.StatusSeeOther, ""},
+		{"/.shrt/ui/", url.Values{"csrf": {csrf}, "key": {"foo"}, "type": {"shrtlnk"}, "url": {"https://example.com/"}}, http.StatusUnprocessableEntity, "key already exists"},
+		{"/.shrt/ui/", url.Values{"csrf": {csrf}, "key": {"a b"}, "type": {"shrtlnk"}, "url": {"https://example.com/"}}, http.StatusUnprocessableEntity, "reserved character"},
+		{"/.shrt/ui/edit/foo", url.Values{"csrf": {csrf}, "action": {"save"}, "type": {"goget"}, "url": {"https://example.com/new"}}, http.StatusSeeOther, ""},
+		{"/.shrt/ui/edit/bar", url.Values{"csrf": {csrf}, "action": {"delete"}}, http.StatusSeeOther, ""},
+		{"/.shrt/ui/edit/bar", url.Values{"csrf": {csrf}, "action": {"delete"}}, http.StatusUnprocessableEntity, "key does not exist"},
+	}
+	for _, tt := range tests {
+		w := uiRequest(u, http.MethodPost, tt.path, tt.form)
+		if w.Code != tt.code || !strings.Contains(w.Body.String(), tt.contains) {
+			t.Errorf("POST %s %v: got status %d, want %d and %q:\n%s",
+				tt.path, tt.form, w.Code, tt.code, tt.contains, w.Body)
+		}
+		if w.Code == http.StatusSeeOther && !strings.HasPrefix(w.Header().Get("Location"), "/.shrt/ui/?") {
+			t.Errorf("POST %s: redirected to %s", tt.path, w.Header().Get("Location"))
+		}
+	}
+
+	data, err := os.ReadFile(u.Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "foo=goget:https://example.com/new\nbaz=shrtlnk:https://example.com/baz\n"
+	if string(data) != want {
+		t.Errorf("unexpected database file:\n%s\nwant:\n%s", data, want)
+	}
+	if _, err := u.Handler.ShrtFile.Get("baz"); err != nil {
+		t.Errorf("change not reloaded: %v", err)
+	}
+}
@@ -0,0 +1,141 @@
+// See LICENSE file for copyright and license details
+
+package shrt
+
+import (
+	"context"
+	"io/fs"
+	"os"
+	"time"
+)
+
+// Default polling parameters for a Watcher.
+const (
+	DefaultWatchInterval = 2 * time.Second
+	DefaultWatchDebounce = 500 * time.Millisecond
+)
+
+// A Watcher polls a database file for changes and reloads it once
+// the changes settle. Polling works on every platform and notices
+// files that are edited in place as well as files that are replaced
+// by renaming another file over them. A file that goes missing is
+// not reloaded until it has been missing for the whole debounce
+// period, so the brief absence during a replace goes unnoticed.
+//
+// Only the database file itself is watched. The [ShrtFile] format has
+// no include directive, so no other file can affect its contents.
+type Watcher struct {
+	// ShrtFile is the ShrtFile to reload. It is ignored if Reload
+	// is set.
+	ShrtFile *ShrtFile
+	// FS and Name locate the database file.
+	FS   fs.FS
+	Name string
+	// Interval is how often the file is polled. If zero,
+	// DefaultWatchInterval is used.
+	Interval time.Duration
+	// Debounce is how long the file must go unchanged after a
+	// change is noticed before it is reloaded. If zero,
+	// DefaultWatchDebounce is used.
+	Debounce time.Duration
+	// Reload, if not nil, is called to reload the database
+	// instead of reading Name into ShrtFile. For example, it may
+	// be set to the Reload method of a ShrtHandler.
+	Reload func() error
+	// Notify, if not nil, is called with the result of each
+	// reload.
+	Notify func(error)
+}
+
+// Watch polls the file name in fsys and reads it into s each time it
+// changes, until ctx is done. If notify is not nil, it is called with
+// the result of each read. See [Watcher] for details.
+func (s *ShrtFile) Watch(ctx context.Context, fsys fs.FS, name string, notify func(error)) error {
+	w := &Watcher{ShrtFile: s, FS: fsys, Name: name, Notify: notify}
+	return w.Run(ctx)
+}
+
+// fileState is the part of a file's metadata that is compared to
+// detect changes.
+type fileState struct {
+	info fs.FileInfo // nil if the file could not be stat'd
+}
+
+func (a fileState) equal(b fileState) bool {
+	if a.info == nil || b.info == nil {
+		return a.info == nil && b.info == nil
+	}
+	if a.info.Size() != b.info.Size() || !a.info.ModTime().Equal(b.info.ModTime()) {
+		return false
+	}
+	// Catch a rename-replace that preserves size and modification
+	// time. Only files from the os package can be compared this way.
+	if a.info.Sys() != nil && b.info.Sys() != nil {
+		return os.SameFile(a.info, b.info)
+	}
+	return true
+}
+
+// Run polls the file until ctx is done, and returns ctx.Err().
+func (w *Watcher) Run(ctx context.Context) error {
+	interval, debounce := w.Interval, w.Debounce
+	if interval <= 0 {
+		interval = DefaultWatchInterval
+	}
+	if debounce <= 0 {
+		debounce = DefaultWatchDebounce
+	}
+
+	var (
+		last    = w.stat()
+		pending *fileState
+		timer   = time.NewTimer(interval)
+	)
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-timer.C:
+		}
+		cur := w.stat()
+		switch {
+		case pending != nil && cur.equal(*pending):
+			// The change has settled
+			pending = nil
+			if !cur.equal(last) {
+				last = cur
+				w.reload()
+			}
+			timer.Reset(interval)
+		case pending != nil || !cur.equal(last):
+			pending = &cur
+			timer.Reset(debounce)
+		default:
+			timer.Reset(interval)
+		}
+	}
+}
+
+func (w *Watcher) stat() fileState {
+	info, err := fs.Stat(w.FS, w.Name)
+	if err != nil {
+		return fileState{}
+	}
+	return fileState{info: info}
+}
+
+func (w *Watcher) reload() {
+	var err error
+	if w.Reload != nil {
+		err = w.Reload()
+	} else {
+		var f fs.File
+		if f, err = w.FS.Open(w.Name); err == nil {
+			err = w.ShrtFile.ReadShrtFile(f)
+		}
+	}
+	if w.Notify != nil {
+		w.Notify(err)
+	}
+}
@@ -0,0 +1,62 @@
+// See LICENSE file for copyright and license details
+
+package shrt
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestWatcher(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "shrt.db")
+	if err := os.WriteFile(path, []byte(testdb), 0666); err != nil {
+		t.Fatal(err)
+	}
+	s := NewShrtFile()
+	reloads := make(chan error, 10)
+	w := &Watcher{
+		ShrtFile: s,
+		FS:       os.DirFS(dir),
+		Name:     "shrt.db",
+		Interval: 10 * time.Millisecond,
+		Debounce: 30 * time.Millisecond,
+		Notify:   func(err error) { reloads <- err },
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- w.Run(ctx) }()
+	// Give the watcher time to stat the original file
+	time.Sleep(50 * time.Millisecond)
+
+	// Replace the file by renaming another over it
+	tmp := filepath.Join(dir, "shrt.db.tmp")
+	if err := os.WriteFile(tmp, []byte("baz=shrtlnk:https://example.com/baz\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-reloads:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for reload")
+	}
+	if _, err := s.Get("baz"); err != nil {
+		t.Error(err)
+	}
+
+	cancel()
+	if err := <-done; err != context.Canceled {
+		t.Errorf("got %v, want %v", err, context.Canceled)
+	}
+	if len(reloads) != 0 {
+		t.Errorf("got %d extra reloads", len(reloads))
+	}
+}